@@ -0,0 +1,240 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff     = 30 * time.Second
+)
+
+// Client of the image microservice. It is safe for concurrent use
+type Client struct {
+	baseURL      string
+	key          string
+	httpClient   *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+type Option func(*Client)
+
+// Use the HTTP client to send the requests instead of http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// Retry the failed requests at most maxRetries times, waiting an exponential backoff starting at backoff between two
+// attempts. A maxRetries of 0 disables the retries
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryBackoff = backoff
+	}
+}
+
+// Return a client sending the requests to the service at baseURL, authenticated with the JWT or the API key
+func New(baseURL, key string, options ...Option) *Client {
+	c := &Client{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		key:          key,
+		httpClient:   http.DefaultClient,
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Body of a request. It is created again for every attempt so that the requests can be retried
+type requestBody struct {
+	contentType string
+	open        func() (io.Reader, error)
+	// sent in the Idempotency-Key header so that a POST can be retried without being processed twice
+	idempotencyKey string
+}
+
+// Return the body encoding the value in JSON
+func jsonBody(value interface{}) (*requestBody, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &requestBody{contentType: "application/json", open: func() (io.Reader, error) {
+		return bytes.NewReader(encoded), nil
+	}}, nil
+}
+
+// Send the request and decode the JSON response into out, retrying the attempts that failed transiently. The error
+// responses of the service are returned as *common.ErrorResponseError
+func (c *Client) doJSON(ctx context.Context, method, path string, body *requestBody, out interface{}) (http.Header,
+	error) {
+	response, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if out != nil {
+		err = json.NewDecoder(response.Body).Decode(out)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response.Header, nil
+}
+
+// Send the request and return the successful response. The caller must close its body
+func (c *Client) do(ctx context.Context, method, path string, body *requestBody) (*http.Response, error) {
+	idempotent := isIdempotent(method) || body != nil && body.idempotencyKey != ""
+	for attempt := 0; ; attempt++ {
+		response, err := c.attempt(ctx, method, path, body)
+		if err == nil && response.StatusCode < 400 {
+			return response, nil
+		}
+
+		var retryAfter time.Duration
+		if err == nil {
+			retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+			err = decodeError(response)
+			if !isRetryableError(idempotent, err) {
+				return nil, err
+			}
+		} else if ctx.Err() != nil || !idempotent {
+			// The request may have been processed if the connection failed after it was sent
+			return nil, err
+		}
+		if attempt >= c.maxRetries {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Send a single attempt of the request
+func (c *Client) attempt(ctx context.Context, method, path string, body *requestBody) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		var err error
+		reader, err = body.open()
+		if err != nil {
+			return nil, err
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", body.contentType)
+	}
+	if body != nil && body.idempotencyKey != "" {
+		request.Header.Set("Idempotency-Key", body.idempotencyKey)
+	}
+	request.Header.Set("Key", c.key)
+	return c.httpClient.Do(request)
+}
+
+// Return the exponential backoff with jitter to wait before the next attempt
+func (c *Client) backoff(attempt int) time.Duration {
+	backoff := c.retryBackoff << uint(attempt)
+	if backoff > maxRetryBackoff || backoff <= 0 {
+		backoff = maxRetryBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Decode the error response and close its body. The status is kept in the Code of the error
+func decodeError(response *http.Response) error {
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	// The v2 routes respond with problem details
+	var problem common.Problem
+	if strings.HasPrefix(response.Header.Get("Content-Type"), "application/problem+json") &&
+		json.Unmarshal(body, &problem) == nil {
+		return &common.ErrorResponseError{
+			Id:            problem.Id,
+			Name:          problem.Name,
+			Detail:        problem.Detail,
+			Code:          int32(response.StatusCode),
+			InvalidParams: problem.InvalidParams,
+		}
+	}
+
+	var decoded common.ErrorResponse
+	if json.Unmarshal(body, &decoded) != nil || decoded.Error_ == nil {
+		return &common.ErrorResponseError{
+			Name:   http.StatusText(response.StatusCode),
+			Detail: strings.TrimSpace(string(body)),
+			Code:   int32(response.StatusCode),
+		}
+	}
+	decoded.Error_.Code = int32(response.StatusCode)
+	return decoded.Error_
+}
+
+// Return true if the request can be sent again after failing with the error. Rate limited requests weren't processed
+// so they are always retried while the server errors are only retried for the idempotent requests. A request whose
+// Idempotency-Key is still used by a previous attempt is retried until that attempt completes
+func isRetryableError(idempotent bool, err error) bool {
+	errResponse, ok := err.(*common.ErrorResponseError)
+	if !ok {
+		return false
+	}
+	switch errResponse.Code {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	case http.StatusConflict:
+		return errResponse.Id == common.IdempotencyKeyInProgressError.Id
+	}
+	return false
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodPut ||
+		method == http.MethodDelete
+}
+
+// Parse the number of seconds of a Retry-After header
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/wtrep/shopify-backend-challenge-image/image"
+)
+
+// Number of images requested per page by the iterators
+const listPageSize = 100
+
+var ErrUploadNotRetryable = errors.New("error the upload can't be retried since the file isn't seekable")
+
+// Create the record of an image. Its file is uploaded afterwards with UploadImage. The request is sent with an
+// Idempotency-Key so that its retries never create the image twice
+func (c *Client) CreateImage(ctx context.Context, request image.CreateImageRequest) (*image.CreateImageResponse,
+	error) {
+	body, err := jsonBody(request)
+	if err != nil {
+		return nil, err
+	}
+	body.idempotencyKey = uuid.New().String()
+	var response image.CreateImageResponse
+	_, err = c.doJSON(ctx, http.MethodPost, "/image", body, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Return an uploaded image along with a temporary download link
+func (c *Client) GetImage(ctx context.Context, id string) (*image.LinkedImageResponse, error) {
+	var response image.LinkedImageResponse
+	_, err := c.doJSON(ctx, http.MethodGet, "/image/"+url.PathEscape(id), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Move an image to the trash
+func (c *Client) DeleteImage(ctx context.Context, id string) (*image.UnlinkedImageResponse, error) {
+	var response image.UnlinkedImageResponse
+	_, err := c.doJSON(ctx, http.MethodDelete, "/image/"+url.PathEscape(id), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Restore a trashed image
+func (c *Client) RestoreImage(ctx context.Context, id string) (*image.UnlinkedImageResponse, error) {
+	var response image.UnlinkedImageResponse
+	_, err := c.doJSON(ctx, http.MethodPost, "/image/"+url.PathEscape(id)+"/restore", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Return the storage usage and the quota of the user
+func (c *Client) GetUsage(ctx context.Context) (*image.UsageResponse, error) {
+	var response image.UsageResponse
+	_, err := c.doJSON(ctx, http.MethodGet, "/usage", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Upload the file of an image. The file is streamed to the service without being buffered. It is only sent again
+// after a failed attempt if it implements io.Seeker, otherwise ErrUploadNotRetryable is returned
+func (c *Client) UploadImage(ctx context.Context, id, filename string, file io.Reader) (*image.LinkedImageResponse,
+	error) {
+	boundary := multipart.NewWriter(ioutil.Discard).Boundary()
+	var previous chan struct{}
+	body := &requestBody{
+		contentType:    "multipart/form-data; boundary=" + boundary,
+		idempotencyKey: uuid.New().String(),
+		open: func() (io.Reader, error) {
+			if previous != nil {
+				// The file can only be rewound once the previous attempt stopped reading it
+				<-previous
+				seeker, ok := file.(io.Seeker)
+				if !ok {
+					return nil, ErrUploadNotRetryable
+				}
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					return nil, err
+				}
+			}
+
+			done := make(chan struct{})
+			previous = done
+			reader, writer := io.Pipe()
+			go func() {
+				defer close(done)
+				form := multipart.NewWriter(writer)
+				err := form.SetBoundary(boundary)
+				if err == nil {
+					var part io.Writer
+					part, err = form.CreateFormFile("image", filename)
+					if err == nil {
+						_, err = io.Copy(part, file)
+					}
+				}
+				if err == nil {
+					err = form.Close()
+				}
+				writer.CloseWithError(err)
+			}()
+			return reader, nil
+		},
+	}
+
+	var response image.LinkedImageResponse
+	_, err := c.doJSON(ctx, http.MethodPost, "/upload/"+url.PathEscape(id), body, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Return the file of an uploaded image along with its metadata. The caller must close the file
+func (c *Client) DownloadImage(ctx context.Context, id string) (io.ReadCloser, *image.LinkedImageResponse, error) {
+	metadata, err := c.GetImage(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The signed URL is authenticated by its signature so the key isn't sent to the storage
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.Url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, nil, fmt.Errorf("error downloading the file of %s: %s", id, response.Status)
+	}
+	return response.Body, metadata, nil
+}
+
+type ListImagesOptions struct {
+	// uuid of the organisation whose images are listed. The personal images are listed when empty
+	Organisation string
+	// maximum number of images per page, between 1 and 100. The service default is used when 0
+	Limit int
+}
+
+// Return a page of images starting after the cursor along with the cursor of the next page, which is empty on the
+// last page
+func (c *Client) ListImagesPage(ctx context.Context, options ListImagesOptions, after string) (
+	[]image.UnlinkedImageResponse, string, error) {
+	query := url.Values{}
+	if options.Organisation != "" {
+		query.Set("organisation", options.Organisation)
+	}
+	limit := options.Limit
+	if limit == 0 {
+		limit = listPageSize
+	}
+	query.Set("limit", strconv.Itoa(limit))
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	// Only v2 paginates the listing
+	var response image.ImagesPageResponse
+	_, err := c.doJSON(ctx, http.MethodGet, "/v2/images?"+query.Encode(), nil, &response)
+	if err != nil {
+		return nil, "", err
+	}
+	return response.Items, response.NextCursor, nil
+}
+
+// Iterator over the images of a listing. The pages are requested as the iteration goes on
+type ImageIterator struct {
+	client  *Client
+	ctx     context.Context
+	options ListImagesOptions
+	page    []image.UnlinkedImageResponse
+	index   int
+	after   string
+	last    bool
+	err     error
+}
+
+// Return an iterator over every personal image of the user, or every image of the organisation
+func (c *Client) ListImages(ctx context.Context, options ListImagesOptions) *ImageIterator {
+	return &ImageIterator{client: c, ctx: ctx, options: options, index: -1}
+}
+
+// Move to the next image and return false once every image was read or if a page couldn't be fetched
+func (it *ImageIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.index++
+	for it.index >= len(it.page) {
+		if it.last {
+			return false
+		}
+		it.page, it.after, it.err = it.client.ListImagesPage(it.ctx, it.options, it.after)
+		if it.err != nil {
+			return false
+		}
+		it.last = it.after == ""
+		it.index = 0
+	}
+	return true
+}
+
+// Return the current image
+func (it *ImageIterator) Image() image.UnlinkedImageResponse {
+	return it.page[it.index]
+}
+
+// Return the error that stopped the iteration, if any
+func (it *ImageIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/wtrep/shopify-backend-challenge-image/client"
+	"github.com/wtrep/shopify-backend-challenge-image/image"
+)
+
+type uploadResult struct {
+	Path  string                     `json:"path"`
+	Image *image.LinkedImageResponse `json:"image,omitempty"`
+	Error string                     `json:"error,omitempty"`
+}
+
+type imageResult struct {
+	Uuid  string                       `json:"uuid"`
+	Image *image.UnlinkedImageResponse `json:"image,omitempty"`
+	// file the image was downloaded to
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Return a context cancelled on the first interrupt so that the requests in progress are stopped
+func commandContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+	}()
+	return ctx
+}
+
+// Upload the files and the image files of the directories, several at a time
+func runUpload(args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	organisation := flags.String("organisation", "", "uuid of the organisation owning the uploaded images")
+	concurrency := flags.Int("c", 4, "number of files uploaded at the same time")
+	output := outputFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("at least one file or directory is required")
+	}
+	if *concurrency < 1 {
+		return errors.New("the concurrency must be at least 1")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	paths, err := collectFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	ctx := commandContext()
+	results := make([]uploadResult, len(paths))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = uploadFile(ctx, c, paths[i], *organisation)
+			}
+		}()
+	}
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	rows := make([][]string, 0, len(results))
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+			rows = append(rows, []string{result.Path, "", "", result.Error})
+			continue
+		}
+		rows = append(rows, []string{result.Path, result.Image.Uuid, result.Image.Status, ""})
+	}
+	err = printOutput(*output, results, []string{"PATH", "UUID", "STATUS", "ERROR"}, rows)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed", failed, len(results))
+	}
+	return nil
+}
+
+// Return the files to upload. The directories are walked recursively and only their image files are kept while the
+// files passed explicitly are always uploaded
+func collectFiles(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			hidden := strings.HasPrefix(info.Name(), ".") && path != arg
+			if info.IsDir() && hidden {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() && !hidden && image.IsImageExtension(strings.TrimPrefix(filepath.Ext(path), ".")) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// Create the record of the image and upload its file
+func uploadFile(ctx context.Context, c *client.Client, path, organisation string) uploadResult {
+	result := uploadResult{Path: path}
+	file, err := os.Open(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer file.Close()
+
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	created, err := c.CreateImage(ctx, image.CreateImageRequest{
+		Name:         name,
+		Extension:    strings.ToLower(strings.TrimPrefix(filepath.Ext(base), ".")),
+		Organisation: organisation,
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Image, err = c.UploadImage(ctx, created.Uuid, base, file)
+	if err != nil {
+		result.Error = fmt.Sprintf("uploading the file of %s: %v", created.Uuid, err)
+	}
+	return result
+}
+
+// List the personal images or the images of an organisation
+func runList(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	organisation := flags.String("organisation", "", "uuid of the organisation whose images are listed")
+	max := flags.Int("max", 0, "maximum number of images to list. Every image is listed when 0")
+	output := outputFlag(flags)
+	flags.Parse(args)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	images := make(image.UnlinkedImagesResponse, 0)
+	it := c.ListImages(commandContext(), client.ListImagesOptions{Organisation: *organisation})
+	for (*max == 0 || len(images) < *max) && it.Next() {
+		images = append(images, it.Image())
+	}
+	if it.Err() != nil {
+		return it.Err()
+	}
+
+	rows := make([][]string, 0, len(images))
+	for _, i := range images {
+		rows = append(rows, imageRow(i))
+	}
+	return printOutput(*output, images, imageHeader, rows)
+}
+
+// Print the metadata of uploaded images
+func runGet(args []string) error {
+	return runOnImages("get", args, func(ctx context.Context, c *client.Client, id string) (
+		*image.UnlinkedImageResponse, error) {
+		linked, err := c.GetImage(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		i := unlinked(*linked)
+		return &i, nil
+	})
+}
+
+// Move images to the trash
+func runDelete(args []string) error {
+	return runOnImages("delete", args, func(ctx context.Context, c *client.Client, id string) (
+		*image.UnlinkedImageResponse, error) {
+		return c.DeleteImage(ctx, id)
+	})
+}
+
+// Restore trashed images
+func runRestore(args []string) error {
+	return runOnImages("restore", args, func(ctx context.Context, c *client.Client, id string) (
+		*image.UnlinkedImageResponse, error) {
+		return c.RestoreImage(ctx, id)
+	})
+}
+
+// Run the operation on every image passed as argument and print the results
+func runOnImages(name string, args []string, operation func(ctx context.Context, c *client.Client, id string) (
+	*image.UnlinkedImageResponse, error)) error {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	output := outputFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("at least one uuid is required")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx := commandContext()
+	results := make([]imageResult, 0, flags.NArg())
+	for _, id := range flags.Args() {
+		result := imageResult{Uuid: id}
+		result.Image, err = operation(ctx, c, id)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return printImageResults(*output, results, false)
+}
+
+// Download the files of uploaded images into a directory, named after the images
+func runDownload(args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory the files are written to")
+	output := outputFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("at least one uuid is required")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx := commandContext()
+	results := make([]imageResult, 0, flags.NArg())
+	for _, id := range flags.Args() {
+		result := imageResult{Uuid: id}
+		result.Image, result.Path, err = downloadFile(ctx, c, id, *dir)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return printImageResults(*output, results, true)
+}
+
+// Write the file of the image into the directory and return its path
+func downloadFile(ctx context.Context, c *client.Client, id, dir string) (*image.UnlinkedImageResponse, string,
+	error) {
+	body, linked, err := c.DownloadImage(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(linked.Name)
+	if name == "" || name == "." || name == ".." {
+		name = linked.Uuid
+	}
+	path := filepath.Join(dir, name+"."+linked.Extension)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, "", err
+	}
+
+	i := unlinked(*linked)
+	return &i, path, nil
+}
+
+// Print the results of an operation on several images and return an error if one of them failed
+func printImageResults(format string, results []imageResult, withPath bool) error {
+	header := imageHeader
+	if withPath {
+		header = append([]string{"PATH"}, header...)
+	}
+	header = append(header, "ERROR")
+
+	rows := make([][]string, 0, len(results))
+	failed := 0
+	for _, result := range results {
+		row := make([]string, len(imageHeader))
+		row[0] = result.Uuid
+		if result.Image != nil {
+			row = imageRow(*result.Image)
+		}
+		if withPath {
+			row = append([]string{result.Path}, row...)
+		}
+		if result.Error != "" {
+			failed++
+		}
+		rows = append(rows, append(row, result.Error))
+	}
+
+	err := printOutput(format, results, header, rows)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, len(results))
+	}
+	return nil
+}
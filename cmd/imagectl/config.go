@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wtrep/shopify-backend-challenge-image/client"
+)
+
+type config struct {
+	// base URL of the service
+	URL string `json:"url"`
+	// JWT or API key sent in the Key header
+	Key string `json:"key"`
+}
+
+// Return the path of the configuration file, which can be overridden by IMAGECTL_CONFIG
+func configPath() (string, error) {
+	if path := os.Getenv("IMAGECTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "imagectl", "config.json"), nil
+}
+
+// Load the saved configuration. The IMAGECTL_URL and IMAGECTL_KEY environment variables take precedence so that
+// scripts don't need to log in
+func loadConfig() (config, error) {
+	var c config
+	path, err := configPath()
+	if err != nil {
+		return c, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return c, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+		if err != nil {
+			return c, fmt.Errorf("reading %s: %v", path, err)
+		}
+	}
+
+	if url := os.Getenv("IMAGECTL_URL"); url != "" {
+		c.URL = url
+	}
+	if key := os.Getenv("IMAGECTL_KEY"); key != "" {
+		c.Key = key
+	}
+	if c.URL == "" || c.Key == "" {
+		return c, errors.New("not logged in, run imagectl login or set IMAGECTL_URL and IMAGECTL_KEY")
+	}
+	return c, nil
+}
+
+// Return a client authenticated with the saved configuration
+func newClient() (*client.Client, error) {
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(c.URL, c.Key), nil
+}
+
+// Check the credentials against the service and save them. The key is read from the standard input when it isn't
+// passed as a flag so that it doesn't end up in the shell history
+func runLogin(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	url := flags.String("url", "", "base URL of the service")
+	key := flags.String("key", "", "JWT or API key. Read from the standard input if not set")
+	flags.Parse(args)
+	if *url == "" {
+		return errors.New("the -url flag is required")
+	}
+
+	if *key == "" {
+		fmt.Fprint(os.Stderr, "JWT or API key: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		*key = strings.TrimSpace(line)
+	}
+
+	_, err := client.New(*url, *key).GetUsage(context.Background())
+	if err != nil {
+		return fmt.Errorf("checking the credentials: %v", err)
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config{URL: *url, Key: *key}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Logged in, the credentials are saved in", path)
+	return nil
+}
+
+// Remove the saved configuration
+func runLogout(args []string) error {
+	flags := flag.NewFlagSet("logout", flag.ExitOnError)
+	flags.Parse(args)
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Command imagectl manages the images of the image microservice from a terminal or a script
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: imagectl <command> [flags] [arguments]
+
+commands:
+  login     save the URL of the service and the JWT or API key to use
+  logout    remove the saved credentials
+  upload    upload image files and directories
+  list      list the personal images or the images of an organisation
+  get       print the metadata of images
+  download  download the files of images
+  delete    move images to the trash
+  restore   restore trashed images
+
+Every command accepts -o table or -o json. Run imagectl <command> -h for the flags of a command.
+`
+
+var commands = map[string]func(args []string) error{
+	"login":    runLogin,
+	"logout":   runLogout,
+	"upload":   runUpload,
+	"list":     runList,
+	"get":      runGet,
+	"download": runDownload,
+	"delete":   runDelete,
+	"restore":  runRestore,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	err := command(os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "imagectl:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/wtrep/shopify-backend-challenge-image/image"
+)
+
+// Register the -o flag selecting the output format
+func outputFlag(flags *flag.FlagSet) *string {
+	return flags.String("o", "table", "output format: table or json")
+}
+
+// Print the value as indented JSON, or as a table whose rows are built by rows
+func printOutput(format string, value interface{}, header []string, rows [][]string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "table":
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	}
+	return fmt.Errorf("unsupported output format %q", format)
+}
+
+var imageHeader = []string{"UUID", "NAME", "EXTENSION", "STATUS", "HEIGHT", "LENGTH", "REVISION", "ORGANISATION"}
+
+// Return the table row of an image
+func imageRow(i image.UnlinkedImageResponse) []string {
+	return []string{i.Uuid, i.Name, i.Extension, i.Status, fmt.Sprint(i.Height), fmt.Sprint(i.Length),
+		fmt.Sprint(i.Revision), i.Organisation}
+}
+
+// Convert a LinkedImageResponse into the UnlinkedImageResponse printed in the tables
+func unlinked(i image.LinkedImageResponse) image.UnlinkedImageResponse {
+	return image.UnlinkedImageResponse{
+		Uuid:          i.Uuid,
+		Name:          i.Name,
+		Owner:         i.Owner,
+		Extension:     i.Extension,
+		Height:        i.Height,
+		Length:        i.Length,
+		Organisation:  i.Organisation,
+		Status:        i.Status,
+		FailureReason: i.FailureReason,
+		Revision:      i.Revision,
+	}
+}
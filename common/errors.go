@@ -6,6 +6,7 @@ package common
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 )
 
@@ -22,6 +23,20 @@ type ErrorResponseError struct {
 	Detail string `json:"detail,omitempty"`
 	// HTTP Code related to the error
 	Code int32 `json:"-"`
+	// Parts of the request that are invalid, for the validation errors
+	InvalidParams []InvalidParam `json:"invalidParams,omitempty"`
+}
+
+type InvalidParam struct {
+	// Path of the invalid property in the request body
+	Name string `json:"name"`
+	// Reason why the property is invalid
+	Reason string `json:"reason"`
+}
+
+// Return the name, the id and the detail of the error so that it can be used as a Go error
+func (e *ErrorResponseError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Name, e.Id, e.Detail)
 }
 
 var InvalidRequestBodyError = ErrorResponseError{
@@ -171,7 +186,367 @@ var GetImagesDBError = ErrorResponseError{
 	Code:   http.StatusInternalServerError,
 }
 
+var InvalidAPIKeyError = ErrorResponseError{
+	Id:     1222,
+	Name:   "InvalidAPIKeyError",
+	Detail: "The API key provided is invalid or was revoked",
+	Code:   http.StatusUnauthorized,
+}
+
+var InsufficientScopeError = ErrorResponseError{
+	Id:     1223,
+	Name:   "InsufficientScopeError",
+	Detail: "The API key provided doesn't grant the scope required by this operation",
+	Code:   http.StatusForbidden,
+}
+
+var InvalidScopeError = ErrorResponseError{
+	Id:     1224,
+	Name:   "InvalidScopeError",
+	Detail: "One or more of the requested scopes are invalid",
+	Code:   http.StatusBadRequest,
+}
+
+var APIKeyNotFoundError = ErrorResponseError{
+	Id:     1225,
+	Name:   "APIKeyNotFoundError",
+	Detail: "No API key was found with the provided uuid",
+	Code:   http.StatusNotFound,
+}
+
+var APIKeyForbiddenError = ErrorResponseError{
+	Id:     1226,
+	Name:   "APIKeyForbiddenError",
+	Detail: "API keys can't be used to manage API keys, please use a user token",
+	Code:   http.StatusForbidden,
+}
+
+var APIKeyGenerationError = ErrorResponseError{
+	Id:     1227,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var GetAPIKeysDBError = ErrorResponseError{
+	Id:     1228,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var TokenRevokedError = ErrorResponseError{
+	Id:     1229,
+	Name:   "TokenRevokedError",
+	Detail: "The bearer token provided was revoked",
+	Code:   http.StatusUnauthorized,
+}
+
+var AdminRequiredError = ErrorResponseError{
+	Id:     1230,
+	Name:   "AdminRequiredError",
+	Detail: "This operation is restricted to administrators",
+	Code:   http.StatusForbidden,
+}
+
+var TokenRevocationDBError = ErrorResponseError{
+	Id:     1231,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var OrganisationNotFoundError = ErrorResponseError{
+	Id:     1232,
+	Name:   "OrganisationNotFoundError",
+	Detail: "No organisation you are a member of was found with the provided uuid",
+	Code:   http.StatusNotFound,
+}
+
+var OrganisationPermissionDeniedError = ErrorResponseError{
+	Id:     1233,
+	Name:   "OrganisationPermissionDeniedError",
+	Detail: "Your role in the organisation doesn't allow this operation",
+	Code:   http.StatusForbidden,
+}
+
+var InvalidRoleError = ErrorResponseError{
+	Id:     1234,
+	Name:   "InvalidRoleError",
+	Detail: "The role must be one of viewer, editor or admin",
+	Code:   http.StatusBadRequest,
+}
+
+var LastOrganisationAdminError = ErrorResponseError{
+	Id:     1235,
+	Name:   "LastOrganisationAdminError",
+	Detail: "An organisation must keep at least one admin",
+	Code:   http.StatusConflict,
+}
+
+var MemberNotFoundError = ErrorResponseError{
+	Id:     1236,
+	Name:   "MemberNotFoundError",
+	Detail: "The user isn't a member of the organisation",
+	Code:   http.StatusNotFound,
+}
+
+var OrganisationDBError = ErrorResponseError{
+	Id:     1237,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var QuotaExceededError = ErrorResponseError{
+	Id:     1238,
+	Name:   "QuotaExceededError",
+	Detail: "This operation would exceed your storage quota",
+	Code:   http.StatusForbidden,
+}
+
+var UsageDBError = ErrorResponseError{
+	Id:     1239,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var TooManyRequestsError = ErrorResponseError{
+	Id:     1240,
+	Name:   "TooManyRequestsError",
+	Detail: "Too many requests were made, please retry after the delay in the Retry-After header",
+	Code:   http.StatusTooManyRequests,
+}
+
+var ImageStatusConflictError = ErrorResponseError{
+	Id:     1241,
+	Name:   "ImageStatusConflictError",
+	Detail: "The image is currently being uploaded or deleted, please retry later",
+	Code:   http.StatusConflict,
+}
+
+var ImageProcessingFailedError = ErrorResponseError{
+	Id:     1242,
+	Name:   "ImageProcessingFailedError",
+	Detail: "The uploaded file couldn't be processed",
+	Code:   http.StatusUnprocessableEntity,
+}
+
+var ImageUploadFailedError = ErrorResponseError{
+	Id:     1243,
+	Name:   "ImageUploadFailedError",
+	Detail: "The last upload of the image failed, please upload it again",
+	Code:   http.StatusNotFound,
+}
+
+var ImageNotInTrashError = ErrorResponseError{
+	Id:     1244,
+	Name:   "ImageNotInTrashError",
+	Detail: "The image isn't in the trash",
+	Code:   http.StatusConflict,
+}
+
+var RevisionNotFoundError = ErrorResponseError{
+	Id:     1245,
+	Name:   "RevisionNotFoundError",
+	Detail: "No revision of the image was found with the provided number",
+	Code:   http.StatusNotFound,
+}
+
+var BatchSizeError = ErrorResponseError{
+	Id:     1246,
+	Name:   "BatchSizeError",
+	Detail: "A batch must contain between 1 and 100 items",
+	Code:   http.StatusBadRequest,
+}
+
+var InvalidArchiveError = ErrorResponseError{
+	Id:     1247,
+	Name:   "InvalidArchiveError",
+	Detail: "Invalid archive body. The archive must be a ZIP file of at most 200 MB in the archive field",
+	Code:   http.StatusBadRequest,
+}
+
+var InvalidArchiveEntryError = ErrorResponseError{
+	Id:     1248,
+	Name:   "InvalidArchiveEntryError",
+	Detail: "The archive entry isn't an image file of at most 10 MB",
+	Code:   http.StatusBadRequest,
+}
+
+var AccountJobNotFoundError = ErrorResponseError{
+	Id:     1249,
+	Name:   "AccountJobNotFoundError",
+	Detail: "No job was found with the provided uuid",
+	Code:   http.StatusNotFound,
+}
+
+var AccountJobDBError = ErrorResponseError{
+	Id:     1250,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var InvalidWebhookError = ErrorResponseError{
+	Id:     1251,
+	Name:   "InvalidWebhookError",
+	Detail: "The url must be an absolute http or https url of at most 255 characters resolving to public addresses " +
+		"and the events must be supported",
+	Code:   http.StatusBadRequest,
+}
+
+var WebhookNotFoundError = ErrorResponseError{
+	Id:     1252,
+	Name:   "WebhookNotFoundError",
+	Detail: "No webhook was found with the provided uuid",
+	Code:   http.StatusNotFound,
+}
+
+var WebhookDeliveryNotFoundError = ErrorResponseError{
+	Id:     1253,
+	Name:   "WebhookDeliveryNotFoundError",
+	Detail: "No delivery of the webhook was found with the provided id",
+	Code:   http.StatusNotFound,
+}
+
+var WebhookDBError = ErrorResponseError{
+	Id:     1254,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var InvalidLastEventIDError = ErrorResponseError{
+	Id:     1255,
+	Name:   "InvalidLastEventIDError",
+	Detail: "The last event id must be the id of an event that was received",
+	Code:   http.StatusBadRequest,
+}
+
+var EventStreamError = ErrorResponseError{
+	Id:     1256,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var EventDBError = ErrorResponseError{
+	Id:     1257,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var FileDownloadError = ErrorResponseError{
+	Id:     1258,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var InvalidCursorError = ErrorResponseError{
+	Id:     1259,
+	Name:   "InvalidCursorError",
+	Detail: "The provided pagination cursor is invalid",
+	Code:   http.StatusBadRequest,
+}
+
+var InvalidPageSizeError = ErrorResponseError{
+	Id:     1260,
+	Name:   "InvalidPageSizeError",
+	Detail: "The page size must be between 1 and 100",
+	Code:   http.StatusBadRequest,
+}
+
+var InvalidImageNameError = ErrorResponseError{
+	Id:     1261,
+	Name:   "InvalidImageNameError",
+	Detail: "The name of the image must contain between 1 and 64 characters",
+	Code:   http.StatusBadRequest,
+}
+
+var ImageUpdateDBError = ErrorResponseError{
+	Id:     1262,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var ProblemTypeNotFoundError = ErrorResponseError{
+	Id:     1263,
+	Name:   "ProblemTypeNotFoundError",
+	Detail: "No problem type was found with the provided id",
+	Code:   http.StatusNotFound,
+}
+
+var InvalidIdempotencyKeyError = ErrorResponseError{
+	Id:     1264,
+	Name:   "InvalidIdempotencyKeyError",
+	Detail: "The Idempotency-Key header must be at most 255 characters long",
+	Code:   http.StatusBadRequest,
+}
+
+var IdempotencyKeyConflictError = ErrorResponseError{
+	Id:     1265,
+	Name:   "IdempotencyKeyConflictError",
+	Detail: "The Idempotency-Key was already used for a different request",
+	Code:   http.StatusUnprocessableEntity,
+}
+
+var IdempotencyKeyInProgressError = ErrorResponseError{
+	Id:     1266,
+	Name:   "IdempotencyKeyInProgressError",
+	Detail: "A request with the same Idempotency-Key is still being handled, please try again",
+	Code:   http.StatusConflict,
+}
+
+var IdempotencyDBError = ErrorResponseError{
+	Id:     1267,
+	Name:   "InternalServerError",
+	Detail: "An unhandled error occurred, please try again",
+	Code:   http.StatusInternalServerError,
+}
+
+var PreconditionFailedError = ErrorResponseError{
+	Id:     1268,
+	Name:   "PreconditionFailedError",
+	Detail: "The image was changed since it was read, please get it again",
+	Code:   http.StatusPreconditionFailed,
+}
+
+var GraphQLQueryTooComplexError = ErrorResponseError{
+	Id:     1269,
+	Name:   "GraphQLQueryTooComplexError",
+	Detail: "The GraphQL operation is nested too deeply or selects too many fields",
+	Code:   http.StatusBadRequest,
+}
+
+// Every error the service can respond with, in the order of their ids. The errors are documented from this list
+var Errors = []*ErrorResponseError{
+	&InvalidRequestBodyError, &UserDoesNotExistError, &WrongPasswordError, &DatabaseInsertionError, &JSONEncoderError,
+	&PasswordTooLongError, &UserAlreadyExistError, &MissingTokenError, &InvalidTokenError, &TokenGenerationError,
+	&WrongUserError, &InvalidImageBodyError, &FileUploadError, &URLGenerationError, &InvalidUUIDError,
+	&ImageNotFoundError, &ImageNotUploadedError, &UserPermissionDeniedError, &FileDeletionError, &DBDeletionError,
+	&GetImagesDBError, &InvalidAPIKeyError, &InsufficientScopeError, &InvalidScopeError, &APIKeyNotFoundError,
+	&APIKeyForbiddenError, &APIKeyGenerationError, &GetAPIKeysDBError, &TokenRevokedError, &AdminRequiredError,
+	&TokenRevocationDBError, &OrganisationNotFoundError, &OrganisationPermissionDeniedError, &InvalidRoleError,
+	&LastOrganisationAdminError, &MemberNotFoundError, &OrganisationDBError, &QuotaExceededError, &UsageDBError,
+	&TooManyRequestsError, &ImageStatusConflictError, &ImageProcessingFailedError, &ImageUploadFailedError,
+	&ImageNotInTrashError, &RevisionNotFoundError, &BatchSizeError, &InvalidArchiveError, &InvalidArchiveEntryError,
+	&AccountJobNotFoundError, &AccountJobDBError, &InvalidWebhookError, &WebhookNotFoundError,
+	&WebhookDeliveryNotFoundError, &WebhookDBError, &InvalidLastEventIDError, &EventStreamError, &EventDBError,
+	&FileDownloadError, &InvalidCursorError, &InvalidPageSizeError, &InvalidImageNameError, &ImageUpdateDBError,
+	&ProblemTypeNotFoundError, &InvalidIdempotencyKeyError, &IdempotencyKeyConflictError,
+	&IdempotencyKeyInProgressError, &IdempotencyDBError, &PreconditionFailedError, &GraphQLQueryTooComplexError,
+}
+
 func RespondWithError(w http.ResponseWriter, error *ErrorResponseError) {
+	if writer := problemWriterOf(w); writer != nil {
+		respondWithProblem(w, writer.Instance, error)
+		return
+	}
 	w.WriteHeader(int(error.Code))
 	response := ErrorResponse{
 		Error_: error,
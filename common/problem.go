@@ -0,0 +1,144 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Path under which the problem types are described, followed by the id of their ErrorResponseError
+const ProblemTypePath = "/problems/"
+
+// Header carrying the id of the request, sent back in every response and in the problem details
+const RequestIDHeader = "X-Request-ID"
+
+// Problem details of an error as defined by RFC 7807
+type Problem struct {
+	// URI identifying the kind of problem, distinct for every ErrorResponseError
+	Type string `json:"type"`
+	// short summary of the kind of problem
+	Title string `json:"title"`
+	// HTTP status of the response
+	Status int32 `json:"status"`
+	// explanation specific to this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// path of the request that caused this occurrence of the problem
+	Instance string `json:"instance,omitempty"`
+	// id of the request, also sent in the X-Request-ID header
+	RequestId string `json:"requestId,omitempty"`
+	// id and name of the ErrorResponseError so that the clients can keep matching on them
+	Id   int32  `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// parts of the request that are invalid, for the validation errors
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// ResponseWriter whose errors are written by RespondWithError as problem details
+type ProblemResponseWriter struct {
+	http.ResponseWriter
+	// path of the request, used as the instance of the problems
+	Instance string
+}
+
+// Return the wrapped ResponseWriter
+func (w *ProblemResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush the wrapped ResponseWriter so that the event streams keep working behind the wrapper
+func (w *ProblemResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Return the error of the id among every error the service can respond with, or nil if there is none
+func ErrorById(id int32) *ErrorResponseError {
+	for _, e := range Errors {
+		if e.Id == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// Return the problem type of the error, the part of the problem details shared by all its occurrences. The title is
+// the default detail of the error
+func NewProblemType(errResponse *ErrorResponseError) Problem {
+	title := http.StatusText(int(errResponse.Code))
+	if e := ErrorById(errResponse.Id); e != nil {
+		title = e.Detail
+	}
+	return Problem{
+		Type:   fmt.Sprintf("%s%d", ProblemTypePath, errResponse.Id),
+		Title:  title,
+		Status: errResponse.Code,
+		Id:     errResponse.Id,
+		Name:   errResponse.Name,
+	}
+}
+
+// Return the problem details of an occurrence of the error
+func NewProblem(errResponse *ErrorResponseError) Problem {
+	problem := NewProblemType(errResponse)
+	problem.Detail = errResponse.Detail
+	problem.InvalidParams = errResponse.InvalidParams
+	return problem
+}
+
+// Return true if the Accept header prefers problem details to the JSON errors. Wildcards don't count as a
+// preference so that the existing clients keep receiving the JSON errors
+func AcceptsProblem(accept string) bool {
+	problemQuality, jsonQuality := 0.0, 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "application/problem+json":
+			problemQuality = quality
+		case "application/json":
+			jsonQuality = quality
+		}
+	}
+	return problemQuality > 0 && problemQuality >= jsonQuality
+}
+
+// Return the ProblemResponseWriter w is or wraps, or nil if the errors written to w use the JSON errors. The
+// ResponseWriters wrapping another one are unwrapped through their Unwrap method
+func problemWriterOf(w http.ResponseWriter) *ProblemResponseWriter {
+	for {
+		switch writer := w.(type) {
+		case *ProblemResponseWriter:
+			return writer
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// Respond with the problem details of the error
+func respondWithProblem(w http.ResponseWriter, instance string, errResponse *ErrorResponseError) {
+	problem := NewProblem(errResponse)
+	problem.Instance = instance
+	problem.RequestId = w.Header().Get(RequestIDHeader)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(int(errResponse.Code))
+	err := json.NewEncoder(w).Encode(&problem)
+	if err != nil {
+		http.Error(w, "Server unhandled error", int(errResponse.Code))
+	}
+}
@@ -0,0 +1,111 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsProblem(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   bool
+	}{
+		{name: "no header", accept: ``},
+		{name: "problem details", accept: `application/problem+json`, want: true},
+		{name: "problem details in upper case", accept: `Application/Problem+JSON`, want: true},
+		{name: "JSON", accept: `application/json`},
+		{name: "wildcard", accept: `*/*`},
+		{name: "application wildcard", accept: `application/*`},
+		{name: "problem details and JSON", accept: `application/json, application/problem+json`, want: true},
+		{name: "problem details preferred", accept: `application/json;q=0.5, application/problem+json`, want: true},
+		{name: "JSON preferred", accept: `application/problem+json;q=0.5, application/json`},
+		{name: "problem details refused", accept: `application/problem+json;q=0`},
+		{name: "spaces around the parameters", accept: ` application/problem+json ; q=0.8 , */*`, want: true},
+		{name: "invalid quality", accept: `application/problem+json;q=high`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AcceptsProblem(tt.accept); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// ResponseWriter wrapping another one the way the middlewares of the service do
+type wrappingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *wrappingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestRespondWithError(t *testing.T) {
+	tests := []struct {
+		name            string
+		wrap            func(w http.ResponseWriter) http.ResponseWriter
+		wantContentType string
+		wantProblem     bool
+	}{
+		{
+			name:            "JSON error",
+			wrap:            func(w http.ResponseWriter) http.ResponseWriter { return w },
+			wantContentType: "application/json",
+		},
+		{
+			name: "problem details",
+			wrap: func(w http.ResponseWriter) http.ResponseWriter {
+				return &ProblemResponseWriter{ResponseWriter: w, Instance: "/image"}
+			},
+			wantContentType: "application/problem+json",
+			wantProblem:     true,
+		},
+		{
+			name: "problem details behind another writer",
+			wrap: func(w http.ResponseWriter) http.ResponseWriter {
+				return &wrappingResponseWriter{&ProblemResponseWriter{ResponseWriter: w, Instance: "/image"}}
+			},
+			wantContentType: "application/problem+json",
+			wantProblem:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			w := tt.wrap(recorder)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(RequestIDHeader, "request")
+			RespondWithError(w, &InvalidRequestBodyError)
+
+			if recorder.Code != int(InvalidRequestBodyError.Code) {
+				t.Errorf("got the status %d, want %d", recorder.Code, InvalidRequestBodyError.Code)
+			}
+			if got := recorder.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("got the content type %q, want %q", got, tt.wantContentType)
+			}
+			if !tt.wantProblem {
+				var response ErrorResponse
+				if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil || response.Error_ == nil ||
+					response.Error_.Id != InvalidRequestBodyError.Id {
+					t.Errorf("got %s, want the JSON error %d", recorder.Body, InvalidRequestBodyError.Id)
+				}
+				return
+			}
+			var problem Problem
+			if err := json.NewDecoder(recorder.Body).Decode(&problem); err != nil {
+				t.Fatalf("decoding the problem: %v", err)
+			}
+			want := NewProblem(&InvalidRequestBodyError)
+			want.Instance = "/image"
+			want.RequestId = "request"
+			if problem.Type != want.Type || problem.Status != want.Status || problem.Detail != want.Detail ||
+				problem.Instance != want.Instance || problem.RequestId != want.RequestId || problem.Id != want.Id {
+				t.Errorf("got %+v, want %+v", problem, want)
+			}
+		})
+	}
+}
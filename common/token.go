@@ -3,12 +3,14 @@ package common
 import (
 	"errors"
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	"os"
 	"time"
 )
 
 const (
-	tokenValidity = time.Hour * 4
+	// Time during which the tokens are valid after being issued
+	TokenValidity = time.Hour * 4
 )
 
 var SigningJWTError = errors.New("error signing jtw token with key")
@@ -16,14 +18,25 @@ var KeyFuncJWTError = errors.New("error with KeyFunc")
 var ParsingJWTError = errors.New("error with JWT parsing")
 var InvalidJWTTokenError = errors.New("error the token is invalid")
 
+type TokenClaims struct {
+	// Username the token was issued to
+	Subject string
+	// Unique id of the token. Empty for tokens issued without a jti claim
+	ID string
+	// Time at which the token was issued
+	IssuedAt time.Time
+}
+
 // Generate a JWT for the provided username
 func GenerateJWT(username string) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 
 	claims["sub"] = username
+	claims["jti"] = uuid.New().String()
+	claims["iat"] = time.Now().Unix()
 	claims["nbf"] = time.Now().Unix()
-	claims["exp"] = time.Now().Add(tokenValidity).Unix()
+	claims["exp"] = time.Now().Add(TokenValidity).Unix()
 	claims["iss"] = "auth microservice"
 
 	key := os.Getenv("JWT_KEY")
@@ -36,6 +49,15 @@ func GenerateJWT(username string) (string, error) {
 
 // Parse the JWT and verify it's validity with the signing private key
 func VerifyJWT(token string) (string, error) {
+	claims, err := ParseJWT(token)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// Parse the JWT, verify it's validity with the signing private key and return its claims
+func ParseJWT(token string) (*TokenClaims, error) {
 	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return "", KeyFuncJWTError
@@ -44,12 +66,27 @@ func VerifyJWT(token string) (string, error) {
 		return []byte(key), nil
 	})
 	if err != nil {
-		return "", ParsingJWTError
+		return nil, ParsingJWTError
+	}
+
+	if !parsedToken.Valid {
+		return nil, InvalidJWTTokenError
 	}
 
-	if parsedToken.Valid {
-		claims := parsedToken.Claims.(jwt.MapClaims)
-		return claims["sub"].(string), nil
+	claims := parsedToken.Claims.(jwt.MapClaims)
+	subject, ok := claims["sub"].(string)
+	if !ok {
+		return nil, InvalidJWTTokenError
+	}
+	parsedClaims := &TokenClaims{Subject: subject}
+	if jti, ok := claims["jti"].(string); ok {
+		parsedClaims.ID = jti
+	}
+	// Tokens issued without iat fall back on nbf, which was always set to the issuing time
+	if iat, ok := claims["iat"].(float64); ok {
+		parsedClaims.IssuedAt = time.Unix(int64(iat), 0)
+	} else if nbf, ok := claims["nbf"].(float64); ok {
+		parsedClaims.IssuedAt = time.Unix(int64(nbf), 0)
 	}
-	return "", InvalidJWTTokenError
+	return parsedClaims, nil
 }
@@ -6,7 +6,13 @@ require (
 	cloud.google.com/go/storage v1.11.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/golang/protobuf v1.4.2
 	github.com/google/uuid v1.1.2
 	github.com/gorilla/mux v1.8.0
+	github.com/graphql-go/graphql v0.8.1
 	golang.org/x/oauth2 v0.0.0-20200902213428-5d25da1a8d43
+	google.golang.org/api v0.30.0
+	google.golang.org/genproto v0.0.0-20200827165113-ac2560b5e952
+	google.golang.org/grpc v1.31.0
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,645 @@
+package image
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+)
+
+type AccountJobKind string
+
+const (
+	// Archive every image file of the user along with a JSON manifest of the metadata
+	AccountJobExport AccountJobKind = "export"
+	// Remove every record and storage object owned by the user
+	AccountJobErasure AccountJobKind = "erasure"
+)
+
+type AccountJobStatus string
+
+const (
+	AccountJobPending   AccountJobStatus = "PENDING"
+	AccountJobRunning   AccountJobStatus = "RUNNING"
+	AccountJobCompleted AccountJobStatus = "COMPLETED"
+	AccountJobFailed    AccountJobStatus = "FAILED"
+)
+
+const (
+	// Prefix of the export archives in the bucket. These objects aren't images and are ignored by the reconciliation
+	exportPrefix       = "exports/"
+	accountJobInterval = time.Minute
+	// A RUNNING job whose progress wasn't updated for this long is considered abandoned and is claimed again
+	accountJobLease = 5 * time.Minute
+	// Name replacing an erased user on the records kept for its organisations. It can't be the name of a user
+	erasedUsername = "[erased]"
+)
+
+type AccountJob struct {
+	UUID        uuid.UUID
+	Kind        AccountJobKind
+	Username    string
+	RequestedBy string
+	Status      AccountJobStatus
+	// Number of images to process and number of images already processed
+	Total     int
+	Processed int
+	Bucket    string
+	// Path of the archive of a completed export
+	BucketPath  string
+	Error       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	CompletedAt sql.NullTime
+}
+
+type ExportManifest struct {
+	Username   string          `json:"username"`
+	ExportedAt time.Time       `json:"exportedAt"`
+	Images     []ExportedImage `json:"images"`
+}
+
+type ExportedImage struct {
+	UnlinkedImageResponse
+	CreatedAt time.Time          `json:"createdAt"`
+	Revisions []ExportedRevision `json:"revisions"`
+}
+
+type ExportedRevision struct {
+	RevisionResponse
+	// path of the file of the revision inside the archive. Empty if the file was missing from the storage
+	File string `json:"file,omitempty"`
+}
+
+// Return a new PENDING job for the user
+func newAccountJob(kind AccountJobKind, username, requestedBy, bucket string) AccountJob {
+	now := time.Now().UTC()
+	job := AccountJob{
+		UUID:        uuid.New(),
+		Kind:        kind,
+		Username:    username,
+		RequestedBy: requestedBy,
+		Status:      AccountJobPending,
+		Bucket:      bucket,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if kind == AccountJobExport {
+		job.BucketPath = exportPrefix + job.UUID.String() + ".zip"
+	}
+	return job
+}
+
+// Convert an AccountJob into an AccountJobResponse object
+func (j AccountJob) toAccountJobResponse(url string) AccountJobResponse {
+	response := AccountJobResponse{
+		Uuid:        j.UUID.String(),
+		Kind:        string(j.Kind),
+		Username:    j.Username,
+		RequestedBy: j.RequestedBy,
+		Status:      string(j.Status),
+		Total:       j.Total,
+		Processed:   j.Processed,
+		Url:         url,
+		Error:       j.Error,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+	}
+	if j.CompletedAt.Valid {
+		response.CompletedAt = &j.CompletedAt.Time
+	}
+	return response
+}
+
+// Background worker running the export and erasure jobs. Jobs are claimed from the database so that a single replica
+// runs each of them and the jobs abandoned by a stopped replica are resumed
+type AccountJobRunner struct {
+	db      *sql.DB
+	reaper  *StorageReaper
+	wakeups chan struct{}
+	// Called after each processed image. Used by the command line to print the progress
+	onProgress func(AccountJob)
+}
+
+// Return an AccountJobRunner working on the jobs of the database. The reaper may be nil when the runner isn't part of
+// the server
+func NewAccountJobRunner(db *sql.DB, reaper *StorageReaper) *AccountJobRunner {
+	return &AccountJobRunner{db: db, reaper: reaper, wakeups: make(chan struct{}, 1)}
+}
+
+// Run the due jobs periodically or as soon as the runner is woken up. This function never returns
+func (r *AccountJobRunner) Run() {
+	ticker := time.NewTicker(accountJobInterval)
+	defer ticker.Stop()
+	for {
+		r.runDueJobs()
+		select {
+		case <-ticker.C:
+		case <-r.wakeups:
+		}
+	}
+}
+
+// Ask the runner to look for new jobs without waiting for the next tick
+func (r *AccountJobRunner) wake() {
+	select {
+	case r.wakeups <- struct{}{}:
+	default:
+	}
+}
+
+// Claim and run the pending and abandoned jobs one at a time
+func (r *AccountJobRunner) runDueJobs() {
+	jobs, err := GetDueAccountJobs(r.db, time.Now().UTC().Add(-accountJobLease))
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+		claimed, err := ClaimAccountJob(r.db, &job, time.Now().UTC().Add(-accountJobLease))
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		if claimed {
+			r.execute(&job)
+		}
+	}
+}
+
+// Run a claimed job to completion and record its outcome
+func (r *AccountJobRunner) execute(job *AccountJob) {
+	var err error
+	switch job.Kind {
+	case AccountJobExport:
+		err = r.exportAccount(job)
+	case AccountJobErasure:
+		err = r.eraseAccount(job)
+	}
+
+	if err != nil {
+		log.Printf("account job %s: %s of %s: %v", job.UUID, job.Kind, job.Username, err)
+		err = FinishAccountJob(r.db, job, AccountJobFailed, err.Error())
+	} else {
+		err = FinishAccountJob(r.db, job, AccountJobCompleted, "")
+	}
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Record that one more image of the job was processed
+func (r *AccountJobRunner) progress(job *AccountJob) error {
+	job.Processed++
+	err := UpdateAccountJobProgress(r.db, job)
+	if err != nil {
+		return err
+	}
+	if r.onProgress != nil {
+		r.onProgress(*job)
+	}
+	return nil
+}
+
+// Write the archive of every image of the user to the storage. Each revision is copied from the storage one at a
+// time and the manifest is written last so that the archive is never held in memory
+func (r *AccountJobRunner) exportAccount(job *AccountJob) error {
+	images, err := GetOwnedImages(r.db, job.Username)
+	if err != nil {
+		return err
+	}
+	job.Total = len(images)
+	job.Processed = 0
+	err = UpdateAccountJobProgress(r.db, job)
+	if err != nil {
+		return err
+	}
+
+	writer, err := newObjectWriter(job.Bucket, job.BucketPath)
+	if err != nil {
+		return err
+	}
+	archive := zip.NewWriter(writer)
+	manifest := ExportManifest{Username: job.Username, ExportedAt: time.Now().UTC(), Images: make([]ExportedImage, 0)}
+	for _, image := range images {
+		exported, err := r.exportImage(archive, image)
+		if err != nil {
+			writer.Abort()
+			return err
+		}
+		manifest.Images = append(manifest.Images, exported)
+		err = r.progress(job)
+		if err != nil {
+			writer.Abort()
+			return err
+		}
+	}
+
+	entry, err := archive.Create("manifest.json")
+	if err == nil {
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(&manifest)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+	return writer.Close()
+}
+
+// Copy the file of every revision of the image into the archive and return the metadata of the image. The files
+// missing from the storage are listed in the manifest without a file
+func (r *AccountJobRunner) exportImage(archive *zip.Writer, image Image) (ExportedImage, error) {
+	exported := ExportedImage{
+		UnlinkedImageResponse: image.toUnlinkedImageResponse(),
+		CreatedAt:             image.CreatedAt,
+		Revisions:             make([]ExportedRevision, 0),
+	}
+	if image.Status == StatusTrashed {
+		trashedAt := image.TrashedAt.Time
+		exported.TrashedAt = &trashedAt
+	}
+
+	revisions, err := GetRevisions(r.db, image.UUID)
+	if err != nil {
+		return exported, err
+	}
+	for _, revision := range revisions {
+		exportedRevision := ExportedRevision{RevisionResponse: revision.toRevisionResponse(image.Revision, "")}
+		object, err := openObject(image.Bucket, revision.BucketPath)
+		if err == storage.ErrObjectNotExist {
+			exported.Revisions = append(exported.Revisions, exportedRevision)
+			continue
+		} else if err != nil {
+			return exported, err
+		}
+
+		// The revision paths are unique per image so they are reused as the paths inside the archive
+		exportedRevision.File = "images/" + path.Base(revision.BucketPath)
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     exportedRevision.File,
+			Method:   zip.Store,
+			Modified: revision.CreatedAt,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, object)
+		}
+		object.Close()
+		if err != nil {
+			return exported, err
+		}
+		exported.Revisions = append(exported.Revisions, exportedRevision)
+	}
+	return exported, nil
+}
+
+// Remove every personal image record of the user and enqueue the deletion of their files, then remove the other
+// records of the user along with the archives of its previous exports. The organisations keep the images the user
+// created for them
+func (r *AccountJobRunner) eraseAccount(job *AccountJob) error {
+	images, err := GetErasableImages(r.db, job.Username)
+	if err != nil {
+		return err
+	}
+	job.Total = len(images)
+	job.Processed = 0
+	err = UpdateAccountJobProgress(r.db, job)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		err = EraseImage(r.db, image)
+		if err != nil {
+			return err
+		}
+		err = r.progress(job)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = EraseAccountRecords(r.db, job.Username)
+	if err != nil {
+		return err
+	}
+	if r.reaper != nil {
+		r.reaper.wake()
+	}
+	return nil
+}
+
+// Run the export or erase command line subcommand for a user. The job is recorded like the ones requested through the
+// API, its progress is logged and the final job is printed as JSON
+func RunAccountJobCommand(kind AccountJobKind, args []string) {
+	flags := flag.NewFlagSet(string(kind), flag.ExitOnError)
+	username := flags.String("username", "", "user whose data is processed")
+	flags.Parse(args)
+	if *username == "" || len(*username) > 32 {
+		log.Fatal("a username of at most 32 characters is required")
+	}
+
+	CheckEnvVariables()
+	db, err := NewConnectionPool()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The job is created as RUNNING so that the runners of the servers never claim it
+	job := newAccountJob(kind, *username, "cli", os.Getenv("BUCKET"))
+	job.Status = AccountJobRunning
+	err = CreateAccountJob(db, job)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runner := NewAccountJobRunner(db, nil)
+	runner.onProgress = func(job AccountJob) {
+		log.Printf("%s of %s: %d/%d images", job.Kind, job.Username, job.Processed, job.Total)
+	}
+	runner.execute(&job)
+
+	url := ""
+	if job.Status == AccountJobCompleted && job.Kind == AccountJobExport {
+		url, err = generateSignedURL(job.Bucket, job.BucketPath)
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	response := job.toAccountJobResponse(url)
+	err = encoder.Encode(&response)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if job.Status != AccountJobCompleted {
+		os.Exit(1)
+	}
+}
+
+type erasableImage struct {
+	UUID       uuid.UUID
+	Bucket     string
+	BucketPath string
+	// The files of a soft deleted image are already enqueued in the storage outbox
+	Deleted bool
+}
+
+// Create a DB record for the job
+func CreateAccountJob(db *sql.DB, job AccountJob) error {
+	uuidToCreate, err := job.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO account_jobs (UUID, kind, username, requestedBy, status, bucket, bucketPath, "+
+		"createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", uuidToCreate, job.Kind, job.Username,
+		job.RequestedBy, job.Status, job.Bucket, job.BucketPath, job.CreatedAt, job.UpdatedAt)
+	return err
+}
+
+const accountJobColumns = "UUID, kind, username, requestedBy, status, total, processed, bucket, bucketPath, error, " +
+	"createdAt, updatedAt, completedAt"
+
+// Return the job record associated to the uuid
+func GetAccountJob(db *sql.DB, id uuid.UUID) (*AccountJob, error) {
+	uuidToGet, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	row := db.QueryRow("SELECT "+accountJobColumns+" FROM account_jobs WHERE UUID = ?", uuidToGet)
+	return scanAccountJob(row)
+}
+
+// Return the PENDING jobs and the RUNNING jobs whose progress wasn't updated since staleBefore, oldest first
+func GetDueAccountJobs(db *sql.DB, staleBefore time.Time) ([]AccountJob, error) {
+	rows, err := db.Query("SELECT "+accountJobColumns+" FROM account_jobs WHERE status = ? "+
+		"OR (status = ? AND updatedAt < ?) ORDER BY createdAt", AccountJobPending, AccountJobRunning, staleBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]AccountJob, 0)
+	for rows.Next() {
+		job, err := scanAccountJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// Move the job to RUNNING. Return false if another worker claimed it first
+func ClaimAccountJob(db *sql.DB, job *AccountJob, staleBefore time.Time) (bool, error) {
+	uuidToClaim, err := job.UUID.MarshalBinary()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	result, err := db.Exec("UPDATE account_jobs SET status = ?, updatedAt = ? WHERE UUID = ? "+
+		"AND (status = ? OR (status = ? AND updatedAt < ?))", AccountJobRunning, now, uuidToClaim,
+		AccountJobPending, AccountJobRunning, staleBefore)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	job.Status = AccountJobRunning
+	job.UpdatedAt = now
+	return affected == 1, nil
+}
+
+// Persist the progress of the job, which also renews its claim
+func UpdateAccountJobProgress(db *sql.DB, job *AccountJob) error {
+	uuidToUpdate, err := job.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	job.UpdatedAt = time.Now().UTC()
+	_, err = db.Exec("UPDATE account_jobs SET total = ?, processed = ?, updatedAt = ? WHERE UUID = ?",
+		job.Total, job.Processed, job.UpdatedAt, uuidToUpdate)
+	return err
+}
+
+// Record the final status of the job along with the error that made it fail
+func FinishAccountJob(db *sql.DB, job *AccountJob, status AccountJobStatus, jobError string) error {
+	uuidToUpdate, err := job.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	jobError = truncateRunes(jobError, 255)
+
+	now := time.Now().UTC()
+	_, err = db.Exec("UPDATE account_jobs SET status = ?, error = ?, updatedAt = ?, completedAt = ? WHERE UUID = ?",
+		status, jobError, now, now, uuidToUpdate)
+	if err != nil {
+		return err
+	}
+
+	job.Status = status
+	job.Error = jobError
+	job.UpdatedAt = now
+	job.CompletedAt = sql.NullTime{Time: now, Valid: true}
+	return nil
+}
+
+// Return the images owned by the user that weren't deleted, including the trashed ones
+func GetOwnedImages(db *sql.DB, username string) ([]Image, error) {
+	rows, err := db.Query("SELECT "+imageColumns+" FROM images WHERE owner = ? AND deletedAt IS NULL "+
+		"ORDER BY createdAt", username)
+	if err != nil {
+		return nil, err
+	}
+	return scanImages(rows)
+}
+
+// Return every personal image record of the user, including the deleted and purged ones. The images the user created
+// inside an organisation are owned by the organisation and aren't erased
+func GetErasableImages(db *sql.DB, username string) ([]erasableImage, error) {
+	rows, err := db.Query("SELECT UUID, bucket, bucketPath, deletedAt IS NOT NULL FROM images WHERE owner = ? "+
+		"AND organisation IS NULL", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := make([]erasableImage, 0)
+	for rows.Next() {
+		var image erasableImage
+		var uuidToParse []byte
+		err = rows.Scan(&uuidToParse, &image.Bucket, &image.BucketPath, &image.Deleted)
+		if err != nil {
+			return nil, err
+		}
+		err = image.UUID.UnmarshalBinary(uuidToParse)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// Remove the image record and its revisions and enqueue the deletion of their files in the storage outbox within the
+// same transaction
+func EraseImage(db *sql.DB, image erasableImage) error {
+	uuidToErase, err := image.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if !image.Deleted {
+		now := time.Now().UTC()
+		_, err = tx.Exec("INSERT INTO storage_outbox (image, bucket, bucketPath, nextAttemptAt, createdAt) "+
+			"VALUES (?, ?, ?, ?, ?)", uuidToErase, image.Bucket, image.BucketPath, now, now)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO storage_outbox (image, bucket, bucketPath, nextAttemptAt, createdAt) "+
+			"SELECT image, ?, bucketPath, ?, ? FROM image_revisions WHERE image = ? AND bucketPath <> ?",
+			image.Bucket, now, now, uuidToErase, image.BucketPath)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM image_revisions WHERE image = ?", uuidToErase)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM images WHERE UUID = ?", uuidToErase)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Remove the API keys, the organisation memberships, the webhooks and the events of the user and enqueue the
+// deletion of the archives of its exports. The user is replaced by erasedUsername on the organisation images it
+// created and on the organisations it created. Every token of the user is revoked so that they can't be used after
+// the erasure
+func EraseAccountRecords(db *sql.DB, username string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM api_keys WHERE owner = ?", []interface{}{username}},
+		{"DELETE FROM organisation_members WHERE username = ?", []interface{}{username}},
+		{"UPDATE images SET owner = ? WHERE owner = ? AND organisation IS NOT NULL",
+			[]interface{}{erasedUsername, username}},
+		{"UPDATE organisations SET createdBy = ? WHERE createdBy = ?", []interface{}{erasedUsername, username}},
+		{"DELETE FROM webhook_deliveries WHERE webhook IN (SELECT UUID FROM webhooks WHERE owner = ?)",
+			[]interface{}{username}},
+		{"DELETE FROM webhooks WHERE owner = ?", []interface{}{username}},
+		{"DELETE FROM events WHERE owner = ?", []interface{}{username}},
+		{"DELETE FROM idempotency_keys WHERE username = ?", []interface{}{username}},
+		{"INSERT INTO token_watermarks (owner, notBefore) VALUES (?, ?) ON DUPLICATE KEY UPDATE " +
+			"notBefore = VALUES(notBefore)", []interface{}{username, now}},
+		{"INSERT INTO storage_outbox (image, bucket, bucketPath, nextAttemptAt, createdAt) " +
+			"SELECT UUID, bucket, bucketPath, ?, ? FROM account_jobs WHERE username = ? AND kind = ? " +
+			"AND bucketPath <> ''", []interface{}{now, now, username, AccountJobExport}},
+		{"UPDATE account_jobs SET bucketPath = '' WHERE username = ? AND kind = ?",
+			[]interface{}{username, AccountJobExport}},
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement.query, statement.args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Scan a row of the account_jobs table selected with accountJobColumns into an AccountJob object
+func scanAccountJob(row rowScanner) (*AccountJob, error) {
+	job := &AccountJob{}
+	var uuidToParse []byte
+
+	err := row.Scan(&uuidToParse, &job.Kind, &job.Username, &job.RequestedBy, &job.Status, &job.Total,
+		&job.Processed, &job.Bucket, &job.BucketPath, &job.Error, &job.CreatedAt, &job.UpdatedAt, &job.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.UUID.UnmarshalBinary(uuidToParse)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
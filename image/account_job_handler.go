@@ -0,0 +1,92 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Handle the admin API request to export every image of a user
+func (h *Handler) HandlePostAccountExport(w http.ResponseWriter, r *http.Request) {
+	h.startAccountJob(w, r, AccountJobExport)
+}
+
+// Handle the admin API request to erase every record and file of a user
+func (h *Handler) HandlePostAccountErasure(w http.ResponseWriter, r *http.Request) {
+	h.startAccountJob(w, r, AccountJobErasure)
+}
+
+// Create a job of the kind for the user of the request and respond with it. The job is run in the background
+func (h *Handler) startAccountJob(w http.ResponseWriter, r *http.Request, kind AccountJobKind) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	username := vars["username"]
+	if username == "" || len(username) > 32 {
+		common.RespondWithError(w, &common.InvalidRequestBodyError)
+		return
+	}
+
+	admin, errResponse := h.authenticateAdmin(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	job := newAccountJob(kind, username, admin, os.Getenv("BUCKET"))
+	err := CreateAccountJob(h.db, job)
+	if err != nil {
+		common.RespondWithError(w, &common.AccountJobDBError)
+		return
+	}
+	h.accountJobs.wake()
+
+	w.WriteHeader(http.StatusAccepted)
+	response := job.toAccountJobResponse("")
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the admin API request to get the progress of a job. A completed export includes a temporary download link
+// to its archive
+func (h *Handler) HandleGetAccountJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	uuidToGet, err := uuid.Parse(vars["uuid"])
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidUUIDError)
+		return
+	}
+
+	_, errResponse := h.authenticateAdmin(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	job, err := GetAccountJob(h.db, uuidToGet)
+	if err != nil {
+		common.RespondWithError(w, &common.AccountJobNotFoundError)
+		return
+	}
+
+	url := ""
+	if job.Kind == AccountJobExport && job.Status == AccountJobCompleted && job.BucketPath != "" {
+		url, err = generateSignedURL(job.Bucket, job.BucketPath)
+		if err != nil {
+			common.RespondWithError(w, &common.URLGenerationError)
+			return
+		}
+	}
+
+	response := job.toAccountJobResponse(url)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
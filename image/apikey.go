@@ -0,0 +1,226 @@
+package image
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	apiKeyPrefix      = "imk_"
+	ScopeImagesRead   = "images:read"
+	ScopeImagesWrite  = "images:write"
+	ScopeImagesDelete = "images:delete"
+	ScopeOrgsRead     = "organisations:read"
+	ScopeOrgsWrite    = "organisations:write"
+)
+
+var validScopes = map[string]bool{
+	ScopeImagesRead:   true,
+	ScopeImagesWrite:  true,
+	ScopeImagesDelete: true,
+	ScopeOrgsRead:     true,
+	ScopeOrgsWrite:    true,
+}
+
+type APIKey struct {
+	UUID       uuid.UUID
+	Owner      string
+	Name       string
+	Prefix     string
+	Hash       string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+// Return true if the key grants the scope. A key without any scope restriction grants every scope
+func (k APIKey) hasScope(scope string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if the token has the format of an API key instead of a JWT
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// Return the hex encoded SHA-256 hash under which an API key is stored
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generate a new API key for the user. The clear key is only returned once and never stored
+func newAPIKey(owner string, request CreateAPIKeyRequest) (APIKey, string, error) {
+	prefix := make([]byte, 4)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(prefix); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+
+	visiblePrefix := apiKeyPrefix + hex.EncodeToString(prefix)
+	clearKey := visiblePrefix + "_" + hex.EncodeToString(secret)
+	key := APIKey{
+		UUID:      uuid.New(),
+		Owner:     owner,
+		Name:      request.Name,
+		Prefix:    visiblePrefix,
+		Hash:      hashAPIKey(clearKey),
+		Scopes:    request.Scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	return key, clearKey, nil
+}
+
+// Return false if one of the requested scopes isn't supported
+func validateScopes(scopes []string) bool {
+	for _, s := range scopes {
+		if !validScopes[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert an APIKey into an APIKeyResponse object
+func (k APIKey) toAPIKeyResponse() APIKeyResponse {
+	response := APIKeyResponse{
+		Uuid:      k.UUID.String(),
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt,
+	}
+	if k.LastUsedAt.Valid {
+		response.LastUsedAt = &k.LastUsedAt.Time
+	}
+	if k.RevokedAt.Valid {
+		response.RevokedAt = &k.RevokedAt.Time
+	}
+	return response
+}
+
+// Convert an array of APIKey into an array of APIKeyResponse object
+func apiKeysToAPIKeysResponse(keys []APIKey) []APIKeyResponse {
+	response := make([]APIKeyResponse, 0)
+	for _, k := range keys {
+		response = append(response, k.toAPIKeyResponse())
+	}
+	return response
+}
+
+// Create a DB record for the API key
+func CreateAPIKey(db *sql.DB, key APIKey) error {
+	uuidToCreate, err := key.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO api_keys (UUID, owner, name, prefix, hash, scopes, createdAt) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?)", uuidToCreate, key.Owner, key.Name, key.Prefix, key.Hash,
+		strings.Join(key.Scopes, ","), key.CreatedAt)
+	return err
+}
+
+// Return the API key matching the hash if it wasn't revoked
+func GetActiveAPIKeyByHash(db *sql.DB, hash string) (*APIKey, error) {
+	row := db.QueryRow("SELECT UUID, owner, name, prefix, hash, scopes, createdAt, lastUsedAt, revokedAt "+
+		"FROM api_keys WHERE hash = ? AND revokedAt IS NULL", hash)
+	return scanAPIKey(row)
+}
+
+// Return the API key record associated to the uuid
+func GetAPIKey(db *sql.DB, id uuid.UUID) (*APIKey, error) {
+	uuidToGet, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	row := db.QueryRow("SELECT UUID, owner, name, prefix, hash, scopes, createdAt, lastUsedAt, revokedAt "+
+		"FROM api_keys WHERE UUID = ?", uuidToGet)
+	return scanAPIKey(row)
+}
+
+// Return the API keys owned by the user, including the revoked ones
+func GetAPIKeys(db *sql.DB, username string) ([]APIKey, error) {
+	rows, err := db.Query("SELECT UUID, owner, name, prefix, hash, scopes, createdAt, lastUsedAt, revokedAt "+
+		"FROM api_keys WHERE owner = ? ORDER BY createdAt", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Mark the API key as revoked so it can't be used anymore
+func RevokeAPIKey(db *sql.DB, id uuid.UUID) error {
+	uuidToRevoke, err := id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE api_keys SET revokedAt = ? WHERE UUID = ? AND revokedAt IS NULL",
+		time.Now().UTC(), uuidToRevoke)
+	return err
+}
+
+// Record the usage of the API key. The timestamp is only refreshed once per minute to limit writes
+func TouchAPIKey(db *sql.DB, id uuid.UUID) error {
+	uuidToTouch, err := id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = db.Exec("UPDATE api_keys SET lastUsedAt = ? WHERE UUID = ? AND (lastUsedAt IS NULL OR lastUsedAt < ?)",
+		now, uuidToTouch, now.Add(-time.Minute))
+	return err
+}
+
+// Scan a row of the api_keys table into an APIKey object
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	key := &APIKey{}
+	var uuidToParse []byte
+	var scopes string
+
+	err := row.Scan(&uuidToParse, &key.Owner, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.CreatedAt,
+		&key.LastUsedAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = key.UUID.UnmarshalBinary(uuidToParse)
+	if err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	return key, nil
+}
@@ -0,0 +1,129 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Handle the API request to create an API key for the authenticated user
+func (h *Handler) HandlePostAPIKey(w http.ResponseWriter, r *http.Request) {
+	var request CreateAPIKeyRequest
+	w.Header().Set("Content-Type", "application/json")
+	errResponse := decodeStrictJSON(r.Body, &request)
+	if errResponse == nil && (request.Name == "" || len(request.Name) > 64) {
+		errResponse = &common.InvalidRequestBodyError
+	}
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if !validateScopes(request.Scopes) {
+		common.RespondWithError(w, &common.InvalidScopeError)
+		return
+	}
+
+	username, errResponse := h.authenticateUser(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	key, clearKey, err := newAPIKey(username, request)
+	if err != nil {
+		common.RespondWithError(w, &common.APIKeyGenerationError)
+		return
+	}
+
+	err = CreateAPIKey(h.db, key)
+	if err != nil {
+		common.RespondWithError(w, &common.DatabaseInsertionError)
+		return
+	}
+
+	response := CreateAPIKeyResponse{
+		APIKeyResponse: key.toAPIKeyResponse(),
+		Key:            clearKey,
+	}
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to list the API keys of the authenticated user
+func (h *Handler) HandleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, errResponse := h.authenticateUser(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	keys, err := GetAPIKeys(h.db, username)
+	if err != nil {
+		common.RespondWithError(w, &common.GetAPIKeysDBError)
+		return
+	}
+
+	response := apiKeysToAPIKeysResponse(keys)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to revoke an API key
+func (h *Handler) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	uuidToRevoke, err := uuid.Parse(vars["uuid"])
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidUUIDError)
+		return
+	}
+
+	username, errResponse := h.authenticateUser(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	key, err := GetAPIKey(h.db, uuidToRevoke)
+	if err != nil || key.Owner != username {
+		common.RespondWithError(w, &common.APIKeyNotFoundError)
+		return
+	}
+
+	err = RevokeAPIKey(h.db, key.UUID)
+	if err != nil {
+		common.RespondWithError(w, &common.DatabaseInsertionError)
+		return
+	}
+
+	key, err = GetAPIKey(h.db, uuidToRevoke)
+	if err != nil {
+		common.RespondWithError(w, &common.GetAPIKeysDBError)
+		return
+	}
+
+	response := key.toAPIKeyResponse()
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Check that the request is authenticated with a user JWT. API keys can't be used to manage API keys
+func (h *Handler) authenticateUser(r *http.Request) (string, *common.ErrorResponseError) {
+	if r.Header["Key"] != nil && isAPIKey(r.Header["Key"][0]) {
+		return "", &common.APIKeyForbiddenError
+	}
+	username, _, errResponse := h.identify(r)
+	return username, errResponse
+}
@@ -0,0 +1,123 @@
+package image
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	maxArchiveSize      = 200 << 20
+	maxArchiveEntrySize = 10 << 20
+)
+
+// Extensions accepted for the images, also used to pick the archive entries imported as images
+var imageExtensions = map[string]bool{
+	"jpg":  true,
+	"jpeg": true,
+	"png":  true,
+	"gif":  true,
+	"webp": true,
+	"bmp":  true,
+}
+
+// Return true if the extension, without its leading dot, is one of the image extensions in any case
+func IsImageExtension(extension string) bool {
+	return imageExtensions[strings.ToLower(extension)]
+}
+
+// Return true if the extension declared for an image is one of the image extensions in lower or upper case
+func isDeclarableExtension(extension string) bool {
+	return imageExtensions[extension] || imageExtensions[strings.ToLower(extension)] &&
+		extension == strings.ToUpper(extension)
+}
+
+// Return the image extensions in alphabetical order
+func imageExtensionList() []string {
+	extensions := make([]string, 0, len(imageExtensions))
+	for extension := range imageExtensions {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// Return the extensions that can be declared for an image, in lower case and then in upper case
+func declarableExtensionList() []string {
+	extensions := imageExtensionList()
+	for _, extension := range imageExtensionList() {
+		extensions = append(extensions, strings.ToUpper(extension))
+	}
+	return extensions
+}
+
+// Return true if the archive entry is a directory or a metadata file added by the operating system that must be
+// ignored silently
+func isIgnoredArchiveEntry(file *zip.File) bool {
+	base := path.Base(file.Name)
+	return file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") || strings.HasPrefix(base, ".")
+}
+
+// Build the request creating the image of an archive entry. ok is false if the entry doesn't have an image extension
+func archiveEntryToCreateImageRequest(file *zip.File, organisation string) (CreateImageRequest, bool) {
+	base := path.Base(file.Name)
+	extension := strings.ToLower(strings.TrimPrefix(path.Ext(base), "."))
+	if !imageExtensions[extension] {
+		return CreateImageRequest{}, false
+	}
+
+	// The name is truncated on a character boundary so that it stays valid UTF-8
+	name := []rune(strings.TrimSuffix(base, path.Ext(base)))
+	if len(name) > maxImageNameLength {
+		name = name[:maxImageNameLength]
+	}
+	return CreateImageRequest{Name: string(name), Extension: extension, Organisation: organisation}, true
+}
+
+// Return the name of the image inside a downloaded archive. The names already used in the archive get a numbered
+// suffix so that no entry is overwritten on extraction
+func archiveEntryName(image Image, used map[string]bool) string {
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(image.Name)
+	if name == "" {
+		name = image.UUID.String()
+	}
+
+	entryName := name + "." + image.Extension
+	for n := 2; used[entryName]; n++ {
+		entryName = fmt.Sprintf("%s (%d).%s", name, n, image.Extension)
+	}
+	used[entryName] = true
+	return entryName
+}
+
+// Write the current file of each image into a ZIP archive streamed to w. The files are copied from the storage one
+// at a time so that the archive is never held in memory
+func writeImagesArchive(w io.Writer, images []Image) error {
+	archive := zip.NewWriter(w)
+	used := make(map[string]bool)
+	for _, image := range images {
+		// Images are already compressed so the entries are only stored
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     archiveEntryName(image, used),
+			Method:   zip.Store,
+			Modified: image.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		object, err := openObject(image.Bucket, image.BucketPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, object)
+		object.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
@@ -0,0 +1,169 @@
+package image
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Handle the API request to import a ZIP archive. An image is created and uploaded for each image entry of the
+// archive, inside an organisation if one is requested
+func (h *Handler) HandlePostArchive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	username, errResponse := h.authenticate(r, ScopeImagesWrite)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	// The multipart form spools the archive to a temporary file so that its entries can be read without holding it
+	// in memory
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveSize)
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidArchiveError)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidArchiveError)
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidArchiveError)
+		return
+	}
+
+	// Every imported entry is an upload
+	if !h.chargeRateLimitItems(w, r, countImportedEntries(archive)) {
+		return
+	}
+
+	organisation := r.FormValue("organisation")
+	response := ArchiveResponse{Results: make([]ArchiveEntryResult, 0)}
+	imported := 0
+	for _, entry := range archive.File {
+		if isIgnoredArchiveEntry(entry) {
+			continue
+		}
+		result := ArchiveEntryResult{Name: entry.Name}
+
+		request, ok := archiveEntryToCreateImageRequest(entry, organisation)
+		if !ok || entry.UncompressedSize64 > maxArchiveEntrySize {
+			result.Error = &common.InvalidArchiveEntryError
+			response.Results = append(response.Results, result)
+			continue
+		}
+		if imported == maxBatchSize {
+			result.Error = &common.BatchSizeError
+			response.Results = append(response.Results, result)
+			continue
+		}
+		imported++
+
+		image, errResponse := h.importArchiveEntry(username, entry, request)
+		if image != nil {
+			imageResponse := image.toUnlinkedImageResponse()
+			result.Image = &imageResponse
+		}
+		result.Error = errResponse
+		response.Results = append(response.Results, result)
+	}
+
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Return the number of entries of the archive that are imported, which are at most maxBatchSize
+func countImportedEntries(archive *zip.Reader) int {
+	count := 0
+	for _, entry := range archive.File {
+		if !isIgnoredArchiveEntry(entry) && count < maxBatchSize {
+			count++
+		}
+	}
+	return count
+}
+
+// Create the image of an archive entry and upload the entry as its file. The image is returned along with the error
+// if the record was created but the upload failed
+func (h *Handler) importArchiveEntry(username string, entry *zip.File, request CreateImageRequest) (*Image,
+	*common.ErrorResponseError) {
+	// The entries are read one at a time and are at most 10 MB like the files of a single upload
+	reader, err := entry.Open()
+	if err != nil {
+		return nil, &common.InvalidArchiveEntryError
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxArchiveEntrySize+1))
+	reader.Close()
+	if err != nil || len(data) > maxArchiveEntrySize {
+		return nil, &common.InvalidArchiveEntryError
+	}
+
+	image, errResponse := h.createImage(username, request)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	errResponse = h.uploadImage(image, bytes.NewReader(data), int64(len(data)))
+	return image, errResponse
+}
+
+// Handle the API request to download the selected images as a ZIP archive. Every image is checked before the
+// archive is streamed since an error can't be reported once the response started
+func (h *Handler) HandleGetArchive(w http.ResponseWriter, r *http.Request) {
+	ids := r.URL.Query()["uuid"]
+	if len(ids) == 0 || len(ids) > maxBatchSize {
+		w.Header().Set("Content-Type", "application/json")
+		common.RespondWithError(w, &common.BatchSizeError)
+		return
+	}
+
+	username, errResponse := h.authenticate(r, ScopeImagesRead)
+	if errResponse != nil {
+		w.Header().Set("Content-Type", "application/json")
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if !h.chargeRateLimitItems(w, r, len(ids)) {
+		return
+	}
+
+	images := make([]Image, 0)
+	for _, id := range ids {
+		parsedID, err := uuid.Parse(id)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			common.RespondWithError(w, &common.InvalidUUIDError)
+			return
+		}
+		image, errResponse := h.getUploadedImage(username, parsedID)
+		if errResponse != nil {
+			w.Header().Set("Content-Type", "application/json")
+			common.RespondWithError(w, errResponse)
+			return
+		}
+		images = append(images, *image)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="images.zip"`)
+	err := writeImagesArchive(w, images)
+	if err != nil {
+		// The status was already sent, the truncated archive fails to open on the client side
+		log.Printf("streaming the archive of %d images: %v", len(images), err)
+	}
+}
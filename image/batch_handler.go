@@ -0,0 +1,154 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+const maxBatchSize = 100
+
+// Handle the API request to create many image records in one call
+func (h *Handler) HandleBatchCreateImages(w http.ResponseWriter, r *http.Request) {
+	var request BatchCreateImagesRequest
+	w.Header().Set("Content-Type", "application/json")
+	errResponse := decodeStrictJSON(r.Body, &request)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if len(request.Images) == 0 || len(request.Images) > maxBatchSize {
+		common.RespondWithError(w, &common.BatchSizeError)
+		return
+	}
+
+	username, errResponse := h.authenticate(r, ScopeImagesWrite)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if !h.chargeRateLimitItems(w, r, len(request.Images)) {
+		return
+	}
+
+	response := BatchCreateImagesResponse{Results: make([]BatchCreateImageResult, 0)}
+	for i, imageRequest := range request.Images {
+		result := BatchCreateImageResult{Index: i}
+		image, errResponse := h.createImage(username, imageRequest)
+		if errResponse != nil {
+			result.Error = errResponse
+		} else {
+			createResponse := image.toCreateImageResponse()
+			result.Image = &createResponse
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	err := json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to get many images by uuid, each with a temporary download link
+func (h *Handler) HandleBatchGetImages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ids, errResponse := decodeBatchUUIDsRequest(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	username, errResponse := h.authenticate(r, ScopeImagesRead)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if !h.chargeRateLimitItems(w, r, len(ids)) {
+		return
+	}
+
+	response := BatchGetImagesResponse{Results: make([]BatchLinkedImageResult, 0)}
+	for _, id := range ids {
+		result := BatchLinkedImageResult{Uuid: id}
+		parsedID, err := uuid.Parse(id)
+		if err != nil {
+			result.Error = &common.InvalidUUIDError
+			response.Results = append(response.Results, result)
+			continue
+		}
+
+		image, url, errResponse := h.getLinkedImage(username, parsedID)
+		if errResponse != nil {
+			result.Error = errResponse
+		} else {
+			linkedResponse := image.toLinkedImageResponse(url)
+			result.Image = &linkedResponse
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	err := json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to delete many images by uuid by moving them to the trash
+func (h *Handler) HandleBatchDeleteImages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ids, errResponse := decodeBatchUUIDsRequest(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	username, errResponse := h.authenticate(r, ScopeImagesDelete)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if !h.chargeRateLimitItems(w, r, len(ids)) {
+		return
+	}
+
+	response := BatchDeleteImagesResponse{Results: make([]BatchUnlinkedImageResult, 0)}
+	for _, id := range ids {
+		result := BatchUnlinkedImageResult{Uuid: id}
+		parsedID, err := uuid.Parse(id)
+		if err != nil {
+			result.Error = &common.InvalidUUIDError
+			response.Results = append(response.Results, result)
+			continue
+		}
+
+		image, errResponse := h.trashImage(username, parsedID, "")
+		if errResponse != nil {
+			result.Error = errResponse
+		} else {
+			trashedResponse := image.toTrashedImageResponse(h.trashRetention)
+			result.Image = &trashedResponse
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	err := json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Decode a BatchUUIDsRequest body and ensure it respects the batch size
+func decodeBatchUUIDsRequest(r *http.Request) ([]string, *common.ErrorResponseError) {
+	var request BatchUUIDsRequest
+	errResponse := decodeStrictJSON(r.Body, &request)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	if len(request.Uuids) == 0 || len(request.Uuids) > maxBatchSize {
+		return nil, &common.BatchSizeError
+	}
+	return request.Uuids, nil
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iterator"
 	"io"
 	"io/ioutil"
 	"log"
@@ -67,7 +68,7 @@ func generateSignedURL(bucket, object string) (string, error) {
 	return u, nil
 }
 
-// Delete a specific file from a determined GCP bucket
+// Delete a specific file from a determined GCP bucket. Deleting a file that doesn't exist succeeds
 func deleteFile(bucket, object string) error {
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
@@ -80,8 +81,119 @@ func deleteFile(bucket, object string) error {
 	defer cancel()
 
 	o := client.Bucket(bucket).Object(object)
-	if err := o.Delete(ctx); err != nil {
+	if err := o.Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
 		return err
 	}
 	return nil
 }
+
+// Copy an object to another path of the same GCP bucket
+func copyObject(bucket, source, destination string) error {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*60)
+	defer cancel()
+
+	b := client.Bucket(bucket)
+	_, err = b.Object(destination).CopierFrom(b.Object(source)).Run(ctx)
+	return err
+}
+
+type storedObject struct {
+	Name    string
+	Size    int64
+	Updated time.Time
+}
+
+// List every object of a determined GCP bucket
+func listObjects(bucket string) ([]storedObject, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+
+	objects := make([]storedObject, 0)
+	it := client.Bucket(bucket).Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, storedObject{Name: attrs.Name, Size: attrs.Size, Updated: attrs.Updated})
+	}
+}
+
+type objectReader struct {
+	*storage.Reader
+	client *storage.Client
+}
+
+// Close the object reader along with its storage client
+func (o objectReader) Close() error {
+	err := o.Reader.Close()
+	o.client.Close()
+	return err
+}
+
+// Open a specific object of a determined GCP bucket for reading. The object is streamed from the storage and the
+// reader must be closed by the caller
+func openObject(bucket, object string) (io.ReadCloser, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := client.Bucket(bucket).Object(object).NewReader(context.Background())
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return objectReader{Reader: reader, client: client}, nil
+}
+
+type objectWriter struct {
+	*storage.Writer
+	client *storage.Client
+	cancel context.CancelFunc
+}
+
+// Close the object writer, which commits the object, along with its storage client
+func (o objectWriter) Close() error {
+	err := o.Writer.Close()
+	o.cancel()
+	o.client.Close()
+	return err
+}
+
+// Abandon the object being written so that it is never committed to the storage
+func (o objectWriter) Abort() {
+	o.cancel()
+	o.Writer.Close()
+	o.client.Close()
+}
+
+// Create a writer streaming a new object to a determined GCP bucket. The object is only committed when the writer is
+// closed
+func newObjectWriter(bucket, object string) (objectWriter, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return objectWriter{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	return objectWriter{Writer: writer, client: client, cancel: cancel}, nil
+}
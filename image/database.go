@@ -2,13 +2,17 @@ package image
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 )
 
+var ErrStaleImage = errors.New("error the image was changed since it was read")
+
 // Return a connection Pool that can query the DB parameterized by environment variables
 func NewConnectionPool() (*sql.DB, error) {
 	dbPassword := os.Getenv("DB_PASSWORD")
@@ -27,7 +31,7 @@ func NewConnectionPool() (*sql.DB, error) {
 		return nil, err
 	}
 
-	err = createTableIfNotExist(db)
+	err = migrate(db)
 	if err != nil {
 		return nil, err
 	}
@@ -35,65 +39,214 @@ func NewConnectionPool() (*sql.DB, error) {
 	return db, nil
 }
 
-// Create the images table if it doesn't exist
-func createTableIfNotExist(db *sql.DB) error {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS images (UUID binary(16) not null primary key, " +
+// Ordered list of the schema migrations. New migrations must only be appended to the list and must only hold
+// literals so that changing the code doesn't change the migrations already applied
+var migrations = []string{
+	"CREATE TABLE IF NOT EXISTS images (UUID binary(16) not null primary key, " +
 		"name varchar(64) not null, owner varchar(32) not null, extension varchar(12) not null, height int null, " +
-		"length int null, bucket varchar(64) not null, bucketPath varchar(128) not null, status varchar(32) null)")
+		"length int null, bucket varchar(64) not null, bucketPath varchar(128) not null, status varchar(32) null)",
+	"CREATE TABLE IF NOT EXISTS api_keys (UUID binary(16) not null primary key, owner varchar(32) not null, " +
+		"name varchar(64) not null, prefix varchar(16) not null, hash char(64) not null unique, " +
+		"scopes varchar(255) not null, createdAt datetime not null, lastUsedAt datetime null, " +
+		"revokedAt datetime null, index (owner))",
+	"CREATE TABLE IF NOT EXISTS revoked_tokens (jti varchar(64) not null primary key, revokedAt datetime not null, " +
+		"expiresAt datetime not null, index (expiresAt))",
+	"CREATE TABLE IF NOT EXISTS token_watermarks (owner varchar(32) not null primary key, notBefore datetime not null)",
+	"CREATE TABLE IF NOT EXISTS organisations (UUID binary(16) not null primary key, name varchar(64) not null, " +
+		"createdBy varchar(32) not null, createdAt datetime not null)",
+	"CREATE TABLE IF NOT EXISTS organisation_members (organisation binary(16) not null, username varchar(32) not null, " +
+		"role varchar(16) not null, primary key (organisation, username), index (username))",
+	"ALTER TABLE images ADD COLUMN organisation binary(16) null, ADD INDEX (owner), ADD INDEX (organisation)",
+	"ALTER TABLE images ADD COLUMN size bigint not null default 0",
+	"ALTER TABLE images ADD COLUMN deletedAt datetime null, ADD COLUMN purgedAt datetime null",
+	"CREATE TABLE IF NOT EXISTS storage_outbox (id bigint not null auto_increment primary key, " +
+		"image binary(16) not null, bucket varchar(64) not null, bucketPath varchar(128) not null, " +
+		"attempts int not null default 0, nextAttemptAt datetime not null, lastError varchar(255) null, " +
+		"createdAt datetime not null, completedAt datetime null, index (completedAt, nextAttemptAt), index (image))",
+	"ALTER TABLE images ADD COLUMN createdAt datetime not null default CURRENT_TIMESTAMP, ADD INDEX (status, createdAt)",
+	"ALTER TABLE images ADD COLUMN failureReason varchar(255) not null default ''",
+	"UPDATE images SET status = 'FAILED', " +
+		"failureReason = 'The uploaded file is missing from the storage, please upload it again' " +
+		"WHERE status = 'MISSING'",
+	"ALTER TABLE images ADD COLUMN trashedAt datetime null, ADD COLUMN trashedFrom varchar(32) not null default '', " +
+		"ADD INDEX (status, trashedAt)",
+	"CREATE TABLE IF NOT EXISTS image_revisions (image binary(16) not null, revision int not null, " +
+		"bucketPath varchar(128) not null, size bigint not null default 0, height int not null default 0, " +
+		"length int not null default 0, createdAt datetime not null, primary key (image, revision))",
+	"ALTER TABLE images ADD COLUMN revision int not null default 0",
+	"INSERT INTO image_revisions (image, revision, bucketPath, size, height, length, createdAt) " +
+		"SELECT UUID, 1, bucketPath, size, COALESCE(height, 0), COALESCE(length, 0), createdAt FROM images " +
+		"WHERE deletedAt IS NULL AND (status = 'UPLOADED' OR trashedFrom = 'UPLOADED')",
+	"UPDATE images SET revision = 1 WHERE deletedAt IS NULL AND (status = 'UPLOADED' OR trashedFrom = 'UPLOADED')",
+	"CREATE TABLE IF NOT EXISTS account_jobs (UUID binary(16) not null primary key, kind varchar(16) not null, " +
+		"username varchar(32) not null, requestedBy varchar(32) not null, status varchar(16) not null, " +
+		"total int not null default 0, processed int not null default 0, bucket varchar(64) not null, " +
+		"bucketPath varchar(128) not null default '', error varchar(255) not null default '', " +
+		"createdAt datetime not null, updatedAt datetime not null, completedAt datetime null, " +
+		"index (status, updatedAt), index (username))",
+	"CREATE TABLE IF NOT EXISTS webhooks (UUID binary(16) not null primary key, owner varchar(32) not null, " +
+		"url varchar(255) not null, secret varchar(64) not null, events varchar(255) not null, " +
+		"createdAt datetime not null, index (owner))",
+	"CREATE TABLE IF NOT EXISTS webhook_deliveries (id bigint not null auto_increment primary key, " +
+		"webhook binary(16) not null, event varchar(32) not null, payload text not null, " +
+		"attempts int not null default 0, lastStatus int not null default 0, lastError varchar(255) not null default '', " +
+		"nextAttemptAt datetime not null, createdAt datetime not null, deliveredAt datetime null, " +
+		"failedAt datetime null, index (deliveredAt, failedAt, nextAttemptAt), index (webhook))",
+	"CREATE TABLE IF NOT EXISTS events (id bigint not null auto_increment primary key, owner varchar(32) not null, " +
+		"type varchar(32) not null, image text not null, createdAt datetime not null, index (owner, id), " +
+		"index (createdAt))",
+	"CREATE TABLE IF NOT EXISTS idempotency_keys (username varchar(32) not null, " +
+		"idempotencyKey varchar(255) not null, fingerprint char(64) not null, status int null, " +
+		"contentType varchar(128) not null default '', body mediumblob null, createdAt datetime not null, " +
+		"primary key (username, idempotencyKey), index (createdAt))",
+	"ALTER TABLE images ADD COLUMN version int not null default 1",
+	"ALTER TABLE images ADD COLUMN statusChangedAt datetime null",
+	"CREATE TABLE IF NOT EXISTS event_sequence (id tinyint not null primary key, value bigint not null)",
+	"INSERT INTO event_sequence (id, value) SELECT 1, COALESCE(MAX(id), 0) FROM events",
+	"CREATE TABLE IF NOT EXISTS usage_locks (owner varchar(32) not null primary key)",
+}
+
+// Apply the migrations that weren't already applied to the database
+func migrate(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version int not null primary key)")
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
 	if err != nil {
 		return err
 	}
+
+	for version := current + 1; version <= len(migrations); version++ {
+		_, err = db.Exec(migrations[version-1])
+		if err != nil {
+			return fmt.Errorf("migration %d: %v", version, err)
+		}
+		_, err = db.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Create a DB record for the specified image
-func CreateImage(db *sql.DB, image Image) error {
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const imageColumns = "UUID, name, owner, extension, height, length, bucket, bucketPath, status, organisation, size, " +
+	"createdAt, failureReason, trashedAt, revision, version"
+
+// Create a DB record for the specified image. Unless maxObjects is 0, the usage row of the owner is locked while its
+// images are counted so that concurrent creations can't exceed the quota together. ErrQuotaExceeded is returned if
+// the owner already has maxObjects images
+func CreateImage(db *sql.DB, image Image, maxObjects int64) error {
 	uuidToCreate, err := image.UUID.MarshalBinary()
 	if err != nil {
 		return err
 	}
+	organisation, err := marshalNullableUUID(image.Organisation)
+	if err != nil {
+		return err
+	}
 
-	_, err = db.Exec("INSERT INTO images (UUID, name, owner, extension, height, length, bucket, bucketPath, "+
-		"status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", uuidToCreate, image.Name, image.Owner, image.Extension, image.Height,
-		image.Length, image.Bucket, image.BucketPath, image.Status)
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	if maxObjects > 0 {
+		objects, err := lockUsage(tx, image.Owner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if objects+1 > maxObjects {
+			tx.Rollback()
+			return ErrQuotaExceeded
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO images (UUID, name, owner, extension, height, length, bucket, bucketPath, "+
+		"status, organisation, size, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", uuidToCreate, image.Name,
+		image.Owner, image.Extension, image.Height, image.Length, image.Bucket, image.BucketPath, image.Status,
+		organisation, image.Size, image.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-// Update the image record with the same uuid as the one that is passed as parameter
-func UpdateImage(db *sql.DB, image Image) error {
+// Update the image record with the same uuid as the one that is passed as parameter and increment its version. The
+// status can only be changed with TransitionImage. ErrStaleImage is returned if the record was changed since the
+// image was read
+func UpdateImage(db *sql.DB, image *Image) error {
 	uuidToUpdate, err := image.UUID.MarshalBinary()
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec("UPDATE images SET name = ?, owner = ?, extension = ?, height = ?, length = ?, bucket = ?, "+
-		"bucketPath = ?, status = ? WHERE uuid = ?", image.Name, image.Owner, image.Extension, image.Height, image.Length,
-		image.Bucket, image.BucketPath, image.Status, uuidToUpdate)
-
+	result, err := db.Exec("UPDATE images SET name = ?, owner = ?, extension = ?, height = ?, length = ?, "+
+		"bucket = ?, bucketPath = ?, size = ?, version = version + 1 WHERE uuid = ? AND version = ? "+
+		"AND deletedAt IS NULL", image.Name, image.Owner, image.Extension, image.Height, image.Length, image.Bucket,
+		image.BucketPath, image.Size, uuidToUpdate, image.Version)
 	if err != nil {
 		return err
 	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return ErrStaleImage
+	}
+
+	image.Version++
 	return nil
 }
 
-// Delete an image record with the provided uuid
-func DeleteImage(db *sql.DB, id uuid.UUID) (*sql.Tx, error) {
-	uuidToDelete, err := id.MarshalBinary()
+// Soft delete the image record and enqueue the deletion of its file in the storage outbox within the same
+// transaction. The StorageReaper deletes the file afterwards and marks the record as purged. ErrInvalidTransition is
+// returned if the image can't be deleted from its status or if its status changed concurrently
+func DeleteImage(db *sql.DB, image Image) error {
+	if !image.Status.canTransitionTo(StatusDeleting) {
+		return ErrInvalidTransition
+	}
+	uuidToDelete, err := image.UUID.MarshalBinary()
 	if err != nil {
-		return nil, err
+		return err
 	}
+
 	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	_, err = tx.Exec("DELETE FROM `images` WHERE `uuid` = ?", uuidToDelete)
+	now := time.Now().UTC()
+	result, err := tx.Exec("UPDATE images SET status = ?, deletedAt = ?, statusChangedAt = ?, version = version + 1 "+
+		"WHERE UUID = ? AND status = ? AND deletedAt IS NULL", StatusDeleting, now, now, uuidToDelete, image.Status)
 	if err != nil {
-		return nil, err
+		tx.Rollback()
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		tx.Rollback()
+		return ErrInvalidTransition
 	}
-	return tx, nil
+
+	// The current file is always enqueued since an upload could still be in progress for an image that isn't
+	// UPLOADED, along with the files of every other revision
+	_, err = tx.Exec("INSERT INTO storage_outbox (image, bucket, bucketPath, nextAttemptAt, createdAt) "+
+		"VALUES (?, ?, ?, ?, ?)", uuidToDelete, image.Bucket, image.BucketPath, now, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO storage_outbox (image, bucket, bucketPath, nextAttemptAt, createdAt) "+
+		"SELECT image, ?, bucketPath, ?, ? FROM image_revisions WHERE image = ? AND bucketPath <> ?", image.Bucket,
+		now, now, uuidToDelete, image.BucketPath)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // Return the record associated to the image uuid
@@ -103,42 +256,133 @@ func GetImage(db *sql.DB, id uuid.UUID) (*Image, error) {
 		return nil, err
 	}
 
-	row := db.QueryRow("SELECT * FROM images WHERE UUID = ?", uuidToGet)
-	image := &Image{}
-	var uuidToParse []byte
+	row := db.QueryRow("SELECT "+imageColumns+" FROM images WHERE UUID = ? AND deletedAt IS NULL", uuidToGet)
+	return scanImage(row)
+}
+
+// Return the record(s) of the personal images owned by the user passed as parameter
+func GetImages(db *sql.DB, username string) ([]Image, error) {
+	rows, err := db.Query("SELECT "+imageColumns+" FROM images WHERE owner = ? AND organisation IS NULL "+
+		"AND status <> ? AND deletedAt IS NULL LIMIT 500", username, StatusTrashed)
+	if err != nil {
+		return nil, err
+	}
+	return scanImages(rows)
+}
 
-	err = row.Scan(&uuidToParse, &image.Name, &image.Owner, &image.Extension, &image.Height, &image.Length, &image.Bucket,
-		&image.BucketPath, &image.Status)
+// Return the record(s) of the images owned by the organisation passed as parameter
+func GetOrganisationImages(db *sql.DB, organisation uuid.UUID) ([]Image, error) {
+	uuidToGet, err := organisation.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 
-	err = image.UUID.UnmarshalBinary(uuidToParse)
+	rows, err := db.Query("SELECT "+imageColumns+" FROM images WHERE organisation = ? AND status <> ? "+
+		"AND deletedAt IS NULL LIMIT 500", uuidToGet, StatusTrashed)
 	if err != nil {
 		return nil, err
 	}
-	return image, nil
+	return scanImages(rows)
 }
 
-// Return the record(s) of the image owned by the user passed as parameter
-func GetImages(db *sql.DB, username string) ([]Image, error) {
-	rows, err := db.Query("SELECT * FROM images WHERE owner = ? LIMIT 500", username)
-	images := make([]Image, 0)
+// Position of an image in the lists ordered by creation time, used by the cursor based pagination
+type ImageCursor struct {
+	CreatedAt time.Time
+	UUID      uuid.UUID
+}
 
-	for rows.Next() {
-		var image Image
-		var uuidToParse []byte
-		err = rows.Scan(&uuidToParse, &image.Name, &image.Owner, &image.Extension, &image.Height, &image.Length,
-			&image.Bucket, &image.BucketPath, &image.Status)
+// Return at most limit personal images of the user, or images of the organisation if one is passed, ordered by
+// creation time and starting after the cursor when there is one
+func GetImagesPage(db *sql.DB, username string, organisation *uuid.UUID, after *ImageCursor,
+	limit int) ([]Image, error) {
+	query := "SELECT " + imageColumns + " FROM images WHERE owner = ? AND organisation IS NULL"
+	args := []interface{}{username}
+	if organisation != nil {
+		uuidToGet, err := organisation.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		query = "SELECT " + imageColumns + " FROM images WHERE organisation = ?"
+		args = []interface{}{uuidToGet}
+	}
+	query += " AND status <> ? AND deletedAt IS NULL"
+	args = append(args, StatusTrashed)
+
+	if after != nil {
+		uuidAfter, err := after.UUID.MarshalBinary()
 		if err != nil {
 			return nil, err
 		}
-		err = image.UUID.UnmarshalBinary(uuidToParse)
+		query += " AND (createdAt > ? OR (createdAt = ? AND UUID > ?))"
+		args = append(args, after.CreatedAt, after.CreatedAt, uuidAfter)
+	}
+	query += " ORDER BY createdAt, UUID LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanImages(rows)
+}
+
+// Scan every row of the images table into an array of Image and close the rows
+func scanImages(rows *sql.Rows) ([]Image, error) {
+	defer rows.Close()
+	images := make([]Image, 0)
+
+	for rows.Next() {
+		image, err := scanImage(rows)
 		if err != nil {
 			return nil, err
 		}
-		images = append(images, image)
+		images = append(images, *image)
 	}
 
-	return images, nil
+	return images, rows.Err()
+}
+
+// Scan a row of the images table selected with imageColumns into an Image object
+func scanImage(row rowScanner) (*Image, error) {
+	image := &Image{}
+	var uuidToParse []byte
+	var organisationToParse []byte
+
+	err := row.Scan(&uuidToParse, &image.Name, &image.Owner, &image.Extension, &image.Height, &image.Length,
+		&image.Bucket, &image.BucketPath, &image.Status, &organisationToParse, &image.Size, &image.CreatedAt,
+		&image.FailureReason, &image.TrashedAt, &image.Revision, &image.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = image.UUID.UnmarshalBinary(uuidToParse)
+	if err != nil {
+		return nil, err
+	}
+	image.Organisation, err = unmarshalNullableUUID(organisationToParse)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// Return the binary value of the uuid or nil to store NULL
+func marshalNullableUUID(id *uuid.UUID) (interface{}, error) {
+	if id == nil {
+		return nil, nil
+	}
+	return id.MarshalBinary()
+}
+
+// Parse a nullable binary uuid column
+func unmarshalNullableUUID(data []byte) (*uuid.UUID, error) {
+	if data == nil {
+		return nil, nil
+	}
+	id := &uuid.UUID{}
+	err := id.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
 }
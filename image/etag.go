@@ -0,0 +1,87 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Length of the windows of time within which a response holding a download link can be cached and revalidated. It is
+// shorter than urlExpirationDelay so that a link is still valid for 10 minutes when it is revalidated
+const signedURLWindow = 5 * time.Minute
+
+var etagWindow = regexp.MustCompile(`-[0-9]+"`)
+
+// Return the ETag of the current version of the image
+func imageETag(image Image) string {
+	return `"v` + strconv.Itoa(image.Version) + `"`
+}
+
+// Return the ETag of a response holding a download link of the current version of the image. The number of the
+// window is added to the version so that the response is no longer revalidated once its link is about to expire
+func linkedImageETag(image Image, now time.Time) string {
+	window := now.UnixNano() / int64(signedURLWindow)
+	return `"v` + strconv.Itoa(image.Version) + "-" + strconv.FormatInt(window, 10) + `"`
+}
+
+// Let the clients cache a response holding a download link until the end of its window
+func setLinkedCacheControl(w http.ResponseWriter, now time.Time) {
+	remaining := signedURLWindow - time.Duration(now.UnixNano()%int64(signedURLWindow))
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(remaining/time.Second)))
+}
+
+// Return the ETag of a listing, derived from the uuid and the version of every image it contains along with the
+// cursor of its next page
+func imagesETag(images []Image, next string) string {
+	hash := sha256.New()
+	for _, image := range images {
+		fmt.Fprintf(hash, "%s:%d\n", image.UUID, image.Version)
+	}
+	fmt.Fprintf(hash, "next:%s", next)
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// Return true if one of the entity tags of the If-Match or If-None-Match header matches the ETag. The weak tags only
+// match with the weak comparison used by If-None-Match
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if the image satisfies the If-Match precondition. Every image satisfies an empty precondition, and the
+// ETags of the responses holding a download link satisfy it whatever their window
+func satisfiesIfMatch(ifMatch string, image Image) bool {
+	return ifMatch == "" || etagMatches(etagWindow.ReplaceAllString(ifMatch, `"`), imageETag(image), false)
+}
+
+// Set the ETag of the response and respond with 304 Not Modified if the If-None-Match header of the request matches
+// it. true is returned if the response was written
+func respondNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag, true) {
+		return false
+	}
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
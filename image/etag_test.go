@@ -0,0 +1,149 @@
+package image
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{name: "same tag", header: `"v3"`, etag: `"v3"`, want: true},
+		{name: "other tag", header: `"v2"`, etag: `"v3"`},
+		{name: "one of the tags", header: `"v1", "v3" ,"v4"`, etag: `"v3"`, want: true},
+		{name: "none of the tags", header: `"v1", "v2"`, etag: `"v3"`},
+		{name: "any tag", header: `*`, etag: `"v3"`, want: true},
+		{name: "any tag among others", header: `"v1", *`, etag: `"v3"`, want: true},
+		{name: "weak tag with the weak comparison", header: `W/"v3"`, etag: `"v3"`, weak: true, want: true},
+		{name: "weak tag with the strong comparison", header: `W/"v3"`, etag: `"v3"`},
+		{name: "weak tag among strong ones", header: `W/"v3", "v3"`, etag: `"v3"`, want: true},
+		{name: "unquoted tag", header: `v3`, etag: `"v3"`},
+		{name: "prefix of the tag", header: `"v3"`, etag: `"v33"`},
+		{name: "empty header", header: ``, etag: `"v3"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, tt.etag, tt.weak); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSatisfiesIfMatch(t *testing.T) {
+	image := Image{Version: 3}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    bool
+	}{
+		{name: "no precondition", ifMatch: ``, want: true},
+		{name: "current version", ifMatch: `"v3"`, want: true},
+		{name: "previous version", ifMatch: `"v2"`},
+		{name: "any version", ifMatch: `*`, want: true},
+		{name: "weak tag", ifMatch: `W/"v3"`},
+		{name: "linked response", ifMatch: linkedImageETag(image, now), want: true},
+		{name: "linked response of an expired window", ifMatch: linkedImageETag(image, now.Add(-time.Hour)),
+			want: true},
+		{name: "linked response of the previous version", ifMatch: linkedImageETag(Image{Version: 2}, now)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := satisfiesIfMatch(tt.ifMatch, image); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkedImageETag(t *testing.T) {
+	image := Image{Version: 3}
+	start := time.Unix(0, 0).Add(1000 * signedURLWindow)
+	tests := []struct {
+		name          string
+		first, second time.Time
+		wantEqual     bool
+	}{
+		{name: "same window", first: start, second: start.Add(signedURLWindow - time.Nanosecond), wantEqual: true},
+		{name: "next window", first: start, second: start.Add(signedURLWindow)},
+		{name: "end of the previous window", first: start, second: start.Add(-time.Nanosecond)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := linkedImageETag(image, tt.first), linkedImageETag(image, tt.second)
+			if (first == second) != tt.wantEqual {
+				t.Errorf("got %s and %s, want them to be equal: %v", first, second, tt.wantEqual)
+			}
+		})
+	}
+	if signedURLWindow >= urlExpirationDelay {
+		t.Errorf("the window of %v doesn't end before the links expire after %v", signedURLWindow,
+			urlExpirationDelay)
+	}
+}
+
+func TestSetLinkedCacheControl(t *testing.T) {
+	start := time.Unix(0, 0).Add(1000 * signedURLWindow)
+	tests := []struct {
+		name string
+		now  time.Time
+		want string
+	}{
+		{name: "start of the window", now: start, want: "private, max-age=300"},
+		{name: "middle of the window", now: start.Add(2 * time.Minute), want: "private, max-age=180"},
+		{name: "end of the window", now: start.Add(signedURLWindow - time.Second), want: "private, max-age=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setLinkedCacheControl(w, tt.now)
+			if got := w.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRespondNotModified(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{name: "no header", ifNoneMatch: ``},
+		{name: "matching tag", ifNoneMatch: `"abc"`, want: true},
+		{name: "matching weak tag", ifNoneMatch: `W/"abc"`, want: true},
+		{name: "any tag", ifNoneMatch: `*`, want: true},
+		{name: "other tag", ifNoneMatch: `"def"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/images", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "application/json")
+
+			got := respondNotModified(w, r, `"abc"`)
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if w.Header().Get("ETag") != `"abc"` {
+				t.Errorf("got the ETag %q, want %q", w.Header().Get("ETag"), `"abc"`)
+			}
+			if got && (w.Code != http.StatusNotModified || w.Header().Get("Content-Type") != "") {
+				t.Errorf("got the status %d and the content type %q, want a 304 without content type", w.Code,
+					w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
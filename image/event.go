@@ -0,0 +1,187 @@
+package image
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEventRetention = 7 * 24 * time.Hour
+	eventPruneInterval    = time.Hour
+	eventPageSize         = 100
+)
+
+type Event struct {
+	// Position of the event in the log, assigned in the order the events are committed. Used as the id of the
+	// Server-Sent Events
+	ID int64
+	// Owner of the image. The events are delivered to the webhooks and the streams of this user
+	Owner     string
+	Type      string
+	Image     UnlinkedImageResponse
+	CreatedAt time.Time
+}
+
+// Return a new event about the image
+func newEvent(eventType string, image Image) Event {
+	return Event{
+		Owner:     image.Owner,
+		Type:      eventType,
+		Image:     image.toUnlinkedImageResponse(),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Convert an Event into an EventPayload object
+func (e Event) toEventPayload() EventPayload {
+	return EventPayload{
+		Id:        strconv.FormatInt(e.ID, 10),
+		Type:      e.Type,
+		CreatedAt: e.CreatedAt,
+		Image:     e.Image,
+	}
+}
+
+// In-process registry of the open event streams. Publishing only signals the streams of the user, which read the
+// events appended to the log after the last one they wrote
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]bool
+}
+
+// Return an EventBroker without any subscriber
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[string]map[chan struct{}]bool)}
+}
+
+// Register a stream of the user. The returned channel receives a signal when new events were appended
+func (b *EventBroker) subscribe(owner string) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	signal := make(chan struct{}, 1)
+	if b.subscribers[owner] == nil {
+		b.subscribers[owner] = make(map[chan struct{}]bool)
+	}
+	b.subscribers[owner][signal] = true
+	return signal
+}
+
+// Remove a stream of the user
+func (b *EventBroker) unsubscribe(owner string, signal chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers[owner], signal)
+	if len(b.subscribers[owner]) == 0 {
+		delete(b.subscribers, owner)
+	}
+}
+
+// Signal every stream of the user that new events were appended
+func (b *EventBroker) publish(owner string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for signal := range b.subscribers[owner] {
+		select {
+		case signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Delete the events older than the retention periodically. This function never returns
+func RunEventPruner(db *sql.DB, retention time.Duration) {
+	ticker := time.NewTicker(eventPruneInterval)
+	defer ticker.Stop()
+	for {
+		err := PruneEvents(db, time.Now().UTC().Add(-retention))
+		if err != nil {
+			log.Println(err.Error())
+		}
+		<-ticker.C
+	}
+}
+
+// Append the event to the log and set its id. The id is taken from the event_sequence row, which stays locked until
+// the event is committed, so the ids grow in the commit order. An auto_increment id would be assigned at insertion and
+// an event committed late could hold a lower id than the ones the streams already read past
+func AppendEvent(db *sql.DB, event *Event) error {
+	image, err := json.Marshal(&event.Image)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	var id int64
+	err = tx.QueryRow("SELECT value FROM event_sequence WHERE id = 1 FOR UPDATE").Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	id++
+	_, err = tx.Exec("INSERT INTO events (id, owner, type, image, createdAt) VALUES (?, ?, ?, ?, ?)", id,
+		event.Owner, event.Type, string(image), event.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE event_sequence SET value = ? WHERE id = 1", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	event.ID = id
+	return nil
+}
+
+// Return the events of the user appended after the id, oldest first
+func GetEventsAfter(db *sql.DB, owner string, afterID int64, limit int) ([]Event, error) {
+	rows, err := db.Query("SELECT id, owner, type, image, createdAt FROM events WHERE owner = ? AND id > ? "+
+		"ORDER BY id LIMIT ?", owner, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var event Event
+		var image string
+		err = rows.Scan(&event.ID, &event.Owner, &event.Type, &image, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(image), &event.Image)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Return the id of the last event committed to the log, 0 if no event was ever appended
+func GetLastEventID(db *sql.DB) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT value FROM event_sequence WHERE id = 1").Scan(&id)
+	return id, err
+}
+
+// Delete the events created before the time
+func PruneEvents(db *sql.DB, createdBefore time.Time) error {
+	_, err := db.Exec("DELETE FROM events WHERE createdAt < ?", createdBefore)
+	return err
+}
@@ -0,0 +1,128 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+const (
+	// The log is also read periodically since the events appended by the other replicas aren't signaled
+	eventPollInterval = 5 * time.Second
+	eventKeepAlive    = 15 * time.Second
+)
+
+// Handle the API request to stream the events of the images of the authenticated user as Server-Sent Events. A client
+// resumes after the last event it received with the Last-Event-ID header or the lastEventId query parameter, otherwise
+// only the new events are streamed
+func (h *Handler) HandleGetEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		common.RespondWithError(w, &common.EventStreamError)
+		return
+	}
+
+	username, errResponse := h.authenticate(r, ScopeImagesRead)
+	if errResponse != nil {
+		w.Header().Set("Content-Type", "application/json")
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	lastEventID, err := parseLastEventID(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		common.RespondWithError(w, &common.InvalidLastEventIDError)
+		return
+	}
+	if lastEventID < 0 {
+		lastEventID, err = GetLastEventID(h.db)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			common.RespondWithError(w, &common.EventDBError)
+			return
+		}
+	}
+
+	// The stream subscribes before reading the log so that no signal is missed between the read and the wait
+	signal := h.events.subscribe(username)
+	defer h.events.unsubscribe(username, signal)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	poll := time.NewTicker(eventPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		lastEventID, err = h.writeEventsAfter(w, username, lastEventID)
+		if err != nil {
+			log.Printf("streaming the events of %s: %v", username, err)
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-signal:
+		case <-poll.C:
+		case <-keepAlive.C:
+			if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Write every event of the user appended after the id and return the id of the last written event
+func (h *Handler) writeEventsAfter(w http.ResponseWriter, username string, afterID int64) (int64, error) {
+	for {
+		events, err := GetEventsAfter(h.db, username, afterID, eventPageSize)
+		if err != nil {
+			return afterID, err
+		}
+
+		for _, event := range events {
+			data, err := json.Marshal(event.toEventPayload())
+			if err != nil {
+				return afterID, err
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			if err != nil {
+				return afterID, err
+			}
+			afterID = event.ID
+		}
+		if len(events) < eventPageSize {
+			return afterID, nil
+		}
+	}
+}
+
+// Return the id of the last event received by the client or -1 if the client didn't receive any event
+func parseLastEventID(r *http.Request) (int64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+	if value == "" {
+		return -1, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid last event id %q", value)
+	}
+	return id, nil
+}
@@ -0,0 +1,487 @@
+package image
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// State shared by the resolvers of a GraphQL request. The user is authenticated once per scope so that the API keys
+// are still restricted to the scopes of the fields they query
+type graphqlSession struct {
+	handler *Handler
+	ctx     context.Context
+	token   string
+	users   map[string]string
+}
+
+type graphqlSessionKey struct{}
+
+type graphqlOrganisation struct {
+	Organisation
+	role Role
+}
+
+type graphqlRevision struct {
+	Revision
+	image *Image
+}
+
+type graphqlImageConnection struct {
+	images      []Image
+	hasNextPage bool
+}
+
+// Return the username of the client if its token grants the scope
+func (s *graphqlSession) authenticate(scope string) (string, error) {
+	if username, ok := s.users[scope]; ok {
+		return username, nil
+	}
+	username, errResponse := s.handler.authenticateToken(s.ctx, s.token, scope)
+	if errResponse != nil {
+		return "", graphqlError(errResponse)
+	}
+	s.users[scope] = username
+	return username, nil
+}
+
+// Return the session of the GraphQL request
+func sessionFrom(ctx context.Context) *graphqlSession {
+	return ctx.Value(graphqlSessionKey{}).(*graphqlSession)
+}
+
+// Error of a field whose extensions are returned along with its message
+type graphqlFieldError struct {
+	message    string
+	extensions map[string]interface{}
+}
+
+func (e *graphqlFieldError) Error() string {
+	return e.message
+}
+
+func (e *graphqlFieldError) Extensions() map[string]interface{} {
+	return e.extensions
+}
+
+// Convert an ErrorResponseError into a GraphQL error. The id and the name of the error are kept in its extensions
+func graphqlError(errResponse *common.ErrorResponseError) error {
+	extensions := map[string]interface{}{
+		"id":   errResponse.Id,
+		"name": errResponse.Name,
+		"code": errResponse.Code,
+	}
+	if len(errResponse.InvalidParams) > 0 {
+		extensions["invalidParams"] = errResponse.InvalidParams
+	}
+	return &graphqlFieldError{message: errResponse.Detail, extensions: extensions}
+}
+
+// Return the value of the optional string argument
+func stringArg(args map[string]interface{}, name string) string {
+	value, _ := args[name].(string)
+	return value
+}
+
+// Return the value of the optional int argument
+func intArg(args map[string]interface{}, name string) int {
+	value, _ := args[name].(int)
+	return value
+}
+
+// Parse the uuid argument of a field
+func uuidArg(args map[string]interface{}, name string) (uuid.UUID, error) {
+	id, err := uuid.Parse(stringArg(args, name))
+	if err != nil {
+		return uuid.UUID{}, graphqlError(&common.InvalidUUIDError)
+	}
+	return id, nil
+}
+
+// Build the GraphQL schema over the images and the organisations. Every field going from an object to another one
+// authorizes the user with the same ownership and membership rules as the REST endpoints
+func newGraphQLSchema(h *Handler) graphql.Schema {
+	// The fields are built lazily since the types reference each other
+	var pageInfoType, imageType, imageEdgeType, imageConnectionType, revisionType, organisationType,
+		memberType *graphql.Object
+
+	connectionArgs := graphql.FieldConfigArgument{
+		"first": {Type: graphql.Int, DefaultValue: defaultPageSize},
+		"after": {Type: graphql.String},
+	}
+	// Resolve a page of the images of the organisation, or of the personal images of the user if it is empty
+	resolveImages := func(ctx context.Context, organisation string, args map[string]interface{}) (interface{},
+		error) {
+		username, err := sessionFrom(ctx).authenticate(ScopeImagesRead)
+		if err != nil {
+			return nil, err
+		}
+		images, hasNextPage, errResponse := h.listImagesPage(username, organisation, intArg(args, "first"),
+			stringArg(args, "after"))
+		if errResponse != nil {
+			return nil, graphqlError(errResponse)
+		}
+		return graphqlImageConnection{images: images, hasNextPage: hasNextPage}, nil
+	}
+
+	pageInfoType = newGraphQLObject("PageInfo", func() graphql.Fields {
+		return graphql.Fields{
+			"hasNextPage": {Type: graphql.NewNonNull(graphql.Boolean), Resolve: func(p graphql.ResolveParams) (
+				interface{}, error) {
+				return p.Source.(graphqlImageConnection).hasNextPage, nil
+			}},
+			"endCursor": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				images := p.Source.(graphqlImageConnection).images
+				if len(images) == 0 {
+					return nil, nil
+				}
+				return imageCursorOf(images[len(images)-1]).encode(), nil
+			}},
+		}
+	})
+
+	imageConnectionType = newGraphQLObject("ImageConnection", func() graphql.Fields {
+		return graphql.Fields{
+			"edges": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(imageEdgeType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(graphqlImageConnection).images, nil
+				}},
+			"pageInfo": {Type: graphql.NewNonNull(pageInfoType), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source, nil
+			}},
+		}
+	})
+
+	imageEdgeType = newGraphQLObject("ImageEdge", func() graphql.Fields {
+		return graphql.Fields{
+			"cursor": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return imageCursorOf(p.Source.(Image)).encode(), nil
+			}},
+			"node": {Type: graphql.NewNonNull(imageType), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				image := p.Source.(Image)
+				return &image, nil
+			}},
+		}
+	})
+
+	imageType = newGraphQLObject("Image", func() graphql.Fields {
+		return graphql.Fields{
+			"uuid": {Type: graphql.NewNonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*Image).UUID.String(), nil
+			}},
+			"name": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*Image).Name, nil
+			}},
+			"owner": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*Image).Owner, nil
+			}},
+			"extension": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*Image).Extension, nil
+			}},
+			"height": {Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*Image).Height, nil
+			}},
+			"length": {Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*Image).Length, nil
+			}},
+			"status": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return string(p.Source.(*Image).Status), nil
+			}},
+			"failureReason": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if reason := p.Source.(*Image).FailureReason; reason != "" {
+					return reason, nil
+				}
+				return nil, nil
+			}},
+			"revision": {Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*Image).Revision, nil
+			}},
+			"createdAt": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*Image).CreatedAt.Format(time.RFC3339), nil
+			}},
+			// Temporary download link of the current file. Null until the image is uploaded
+			"url": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				image := p.Source.(*Image)
+				if image.Status != StatusUploaded {
+					return nil, nil
+				}
+				url, err := generateSignedURL(image.Bucket, image.BucketPath)
+				if err != nil {
+					return nil, graphqlError(&common.URLGenerationError)
+				}
+				return url, nil
+			}},
+			"organisation": {Type: organisationType, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				image := p.Source.(*Image)
+				if image.Organisation == nil {
+					return nil, nil
+				}
+				return h.resolveOrganisation(p.Context, *image.Organisation)
+			}},
+			"revisions": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(revisionType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					image := p.Source.(*Image)
+					revisions, err := GetRevisions(h.db, image.UUID)
+					if err != nil {
+						return nil, graphqlError(&common.GetImagesDBError)
+					}
+					resolved := make([]graphqlRevision, 0, len(revisions))
+					for _, revision := range revisions {
+						resolved = append(resolved, graphqlRevision{Revision: revision, image: image})
+					}
+					return resolved, nil
+				}},
+		}
+	})
+
+	revisionType = newGraphQLObject("Revision", func() graphql.Fields {
+		return graphql.Fields{
+			"revision": {Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(graphqlRevision).Number, nil
+			}},
+			"current": {Type: graphql.NewNonNull(graphql.Boolean), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				revision := p.Source.(graphqlRevision)
+				return revision.Number == revision.image.Revision, nil
+			}},
+			"size": {Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(graphqlRevision).Size, nil
+			}},
+			"height": {Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(graphqlRevision).Height, nil
+			}},
+			"length": {Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(graphqlRevision).Length, nil
+			}},
+			"createdAt": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(graphqlRevision).CreatedAt.Format(time.RFC3339), nil
+			}},
+			"url": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				revision := p.Source.(graphqlRevision)
+				url, err := generateSignedURL(revision.image.Bucket, revision.BucketPath)
+				if err != nil {
+					return nil, graphqlError(&common.URLGenerationError)
+				}
+				return url, nil
+			}},
+		}
+	})
+
+	organisationType = newGraphQLObject("Organisation", func() graphql.Fields {
+		return graphql.Fields{
+			"uuid": {Type: graphql.NewNonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*graphqlOrganisation).UUID.String(), nil
+			}},
+			"name": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*graphqlOrganisation).Name, nil
+			}},
+			"createdBy": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*graphqlOrganisation).CreatedBy, nil
+			}},
+			"createdAt": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(*graphqlOrganisation).CreatedAt.Format(time.RFC3339), nil
+			}},
+			// Role of the user in the organisation
+			"role": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return string(p.Source.(*graphqlOrganisation).role), nil
+			}},
+			"members": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(memberType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					members, err := GetMembers(h.db, p.Source.(*graphqlOrganisation).UUID)
+					if err != nil {
+						return nil, graphqlError(&common.OrganisationDBError)
+					}
+					return members, nil
+				}},
+			"images": {Type: graphql.NewNonNull(imageConnectionType), Args: connectionArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return resolveImages(p.Context, p.Source.(*graphqlOrganisation).UUID.String(), p.Args)
+				}},
+		}
+	})
+
+	memberType = newGraphQLObject("Member", func() graphql.Fields {
+		return graphql.Fields{
+			"username": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return p.Source.(Member).Username, nil
+			}},
+			"role": {Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{},
+				error) {
+				return string(p.Source.(Member).Role), nil
+			}},
+		}
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"image": {Type: imageType, Args: graphql.FieldConfigArgument{"uuid": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := uuidArg(p.Args, "uuid")
+				if err != nil {
+					return nil, err
+				}
+				username, err := sessionFrom(p.Context).authenticate(ScopeImagesRead)
+				if err != nil {
+					return nil, err
+				}
+				image, errResponse := h.getViewableImage(username, id)
+				if errResponse != nil {
+					return nil, graphqlError(errResponse)
+				}
+				return image, nil
+			}},
+		"images": {Type: graphql.NewNonNull(imageConnectionType),
+			Args: graphql.FieldConfigArgument{
+				"organisation": {Type: graphql.ID},
+				"first":        {Type: graphql.Int, DefaultValue: defaultPageSize},
+				"after":        {Type: graphql.String},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return resolveImages(p.Context, stringArg(p.Args, "organisation"), p.Args)
+			}},
+		"organisation": {Type: organisationType,
+			Args: graphql.FieldConfigArgument{"uuid": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := uuidArg(p.Args, "uuid")
+				if err != nil {
+					return nil, err
+				}
+				return h.resolveOrganisation(p.Context, id)
+			}},
+		"organisations": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(organisationType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				username, err := sessionFrom(p.Context).authenticate(ScopeOrgsRead)
+				if err != nil {
+					return nil, err
+				}
+				organisations, roles, err := GetOrganisations(h.db, username)
+				if err != nil {
+					return nil, graphqlError(&common.OrganisationDBError)
+				}
+				resolved := make([]*graphqlOrganisation, 0, len(organisations))
+				for i := range organisations {
+					resolved = append(resolved, &graphqlOrganisation{Organisation: organisations[i], role: roles[i]})
+				}
+				return resolved, nil
+			}},
+	}})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: graphql.Fields{
+		"createImage": {Type: imageType,
+			Args: graphql.FieldConfigArgument{
+				"name":         {Type: graphql.NewNonNull(graphql.String)},
+				"extension":    {Type: graphql.NewNonNull(graphql.String)},
+				"height":       {Type: graphql.Int},
+				"length":       {Type: graphql.Int},
+				"organisation": {Type: graphql.ID},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				username, err := sessionFrom(p.Context).authenticate(ScopeImagesWrite)
+				if err != nil {
+					return nil, err
+				}
+				image, errResponse := h.createImage(username, CreateImageRequest{
+					Name:         stringArg(p.Args, "name"),
+					Extension:    stringArg(p.Args, "extension"),
+					Height:       int32(intArg(p.Args, "height")),
+					Length:       int32(intArg(p.Args, "length")),
+					Organisation: stringArg(p.Args, "organisation"),
+				})
+				if errResponse != nil {
+					return nil, graphqlError(errResponse)
+				}
+				return image, nil
+			}},
+		"updateImage": {Type: imageType,
+			Args: graphql.FieldConfigArgument{
+				"uuid": {Type: graphql.NewNonNull(graphql.ID)},
+				"name": {Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := uuidArg(p.Args, "uuid")
+				if err != nil {
+					return nil, err
+				}
+				username, err := sessionFrom(p.Context).authenticate(ScopeImagesWrite)
+				if err != nil {
+					return nil, err
+				}
+				image, errResponse := h.updateImage(username, id, UpdateImageRequest{Name: stringArg(p.Args, "name")}, "")
+				if errResponse != nil {
+					return nil, graphqlError(errResponse)
+				}
+				return image, nil
+			}},
+		// Move the image to the trash like DELETE /image/{uuid} and return it in its trashed state
+		"deleteImage": {Type: imageType,
+			Args: graphql.FieldConfigArgument{"uuid": {Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := uuidArg(p.Args, "uuid")
+				if err != nil {
+					return nil, err
+				}
+				username, err := sessionFrom(p.Context).authenticate(ScopeImagesDelete)
+				if err != nil {
+					return nil, err
+				}
+				image, errResponse := h.trashImage(username, id, "")
+				if errResponse != nil {
+					return nil, graphqlError(errResponse)
+				}
+				return image, nil
+			}},
+	}})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: queryType, Mutation: mutationType})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Create an object type whose fields are built lazily so that the types can reference each other
+func newGraphQLObject(name string, fields func() graphql.Fields) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{Name: name, Fields: graphql.FieldsThunk(fields)})
+}
+
+// Return the organisation if the user is one of its members, along with the role of the user
+func (h *Handler) resolveOrganisation(ctx context.Context, id uuid.UUID) (interface{}, error) {
+	username, err := sessionFrom(ctx).authenticate(ScopeOrgsRead)
+	if err != nil {
+		return nil, err
+	}
+	role, err := GetMemberRole(h.db, id, username)
+	if err == sql.ErrNoRows {
+		return nil, graphqlError(&common.OrganisationNotFoundError)
+	} else if err != nil {
+		return nil, graphqlError(&common.OrganisationDBError)
+	}
+	organisation, err := GetOrganisation(h.db, id)
+	if err != nil {
+		return nil, graphqlError(&common.OrganisationNotFoundError)
+	}
+	return &graphqlOrganisation{Organisation: *organisation, role: role}, nil
+}
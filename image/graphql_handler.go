@@ -0,0 +1,74 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Handle the API request to execute a GraphQL operation. The errors of the fields are returned in the errors of the
+// GraphQL response while the rest of the data is still resolved. The operations nested too deeply or selecting too
+// many fields are rejected, and the others are charged to the rate limit according to their complexity
+func (h *Handler) HandlePostGraphQL(w http.ResponseWriter, r *http.Request) {
+	var request GraphQLRequest
+	w.Header().Set("Content-Type", "application/json")
+	r.Body = http.MaxBytesReader(w, r.Body, maxGraphQLRequestSize)
+	errResponse := decodeStrictJSON(r.Body, &request)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if request.Query == "" {
+		common.RespondWithError(w, &common.InvalidRequestBodyError)
+		return
+	}
+	if r.Header["Key"] == nil {
+		common.RespondWithError(w, &common.MissingTokenError)
+		return
+	}
+
+	document, result := h.parseGraphQLDocument(request.Query)
+	if result == nil {
+		depth, complexity := measureGraphQLOperation(document, request.OperationName, request.Variables)
+		if depth > maxGraphQLDepth || complexity > maxGraphQLComplexity {
+			common.RespondWithError(w, &common.GraphQLQueryTooComplexError)
+			return
+		}
+		if !h.chargeRateLimitItems(w, r, (complexity+graphqlComplexityPerToken-1)/graphqlComplexityPerToken) {
+			return
+		}
+
+		session := &graphqlSession{handler: h, ctx: r.Context(), token: r.Header["Key"][0], users: map[string]string{}}
+		result = graphql.Execute(graphql.ExecuteParams{
+			Schema:        h.graphqlSchema,
+			AST:           document,
+			OperationName: request.OperationName,
+			Args:          request.Variables,
+			Context:       context.WithValue(r.Context(), graphqlSessionKey{}, session),
+		})
+	}
+	err := json.NewEncoder(w).Encode(result)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Parse the document of the operation and validate it against the schema. When it is invalid, the result holding the
+// errors is returned instead
+func (h *Handler) parseGraphQLDocument(query string) (*ast.Document, *graphql.Result) {
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return nil, &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	validation := graphql.ValidateDocument(&h.graphqlSchema, document, nil)
+	if !validation.IsValid {
+		return nil, &graphql.Result{Errors: validation.Errors}
+	}
+	return document, nil
+}
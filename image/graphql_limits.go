@@ -0,0 +1,125 @@
+package image
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+const (
+	// Maximum size of the body of a GraphQL request
+	maxGraphQLRequestSize = 64 << 10
+	// Maximum nesting of the fields of an operation, which is the depth of
+	// image { organisation { images { edges { node { uuid } } } } }
+	maxGraphQLDepth = 6
+	// Maximum estimated number of fields resolved by an operation
+	maxGraphQLComplexity = 5000
+	// Estimated number of resolved fields charged as a single rate limit token
+	graphqlComplexityPerToken = 100
+	// Estimated size of the lists that aren't paginated
+	graphqlListSize = 10
+)
+
+// Walk the selections of an operation to measure its depth and its complexity, which is the estimated number of
+// fields it resolves: every field counts once per item of the lists it is nested in
+type graphqlMeter struct {
+	fragments  map[string]*ast.FragmentDefinition
+	variables  map[string]interface{}
+	depth      int
+	complexity int
+}
+
+// Return the depth and the complexity of the operation of the document executed for the operation name. The
+// introspection fields aren't measured since they don't reach the database
+func measureGraphQLOperation(document *ast.Document, operationName string, variables map[string]interface{}) (int,
+	int) {
+	meter := &graphqlMeter{fragments: map[string]*ast.FragmentDefinition{}, variables: variables}
+	var operation *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			meter.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			name := ""
+			if definition.Name != nil {
+				name = definition.Name.Value
+			}
+			if operation == nil && (operationName == "" || operationName == name) {
+				operation = definition
+			}
+		}
+	}
+	if operation != nil {
+		meter.measure(operation.SelectionSet, 1, 1)
+	}
+	return meter.depth, meter.complexity
+}
+
+// Add the fields of the selection set found at the depth, each of them being resolved multiplier times
+func (m *graphqlMeter) measure(selectionSet *ast.SelectionSet, depth, multiplier int) {
+	if selectionSet == nil || depth > maxGraphQLDepth+1 || m.complexity > maxGraphQLComplexity {
+		// The operation is already rejected, which also bounds the multiplier
+		return
+	}
+	for _, selection := range selectionSet.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			if depth > m.depth {
+				m.depth = depth
+			}
+			m.complexity += multiplier
+			m.measure(selection.SelectionSet, depth+1, multiplier*m.listSize(selection))
+		case *ast.InlineFragment:
+			m.measure(selection.SelectionSet, depth, multiplier)
+		case *ast.FragmentSpread:
+			// The validation rejects the unknown fragments and the cycles of fragments
+			if fragment, ok := m.fragments[selection.Name.Value]; ok {
+				m.measure(fragment.SelectionSet, depth, multiplier)
+			}
+		}
+	}
+}
+
+// Return the number of items of the list returned by the field, or 1 if it isn't a list
+func (m *graphqlMeter) listSize(field *ast.Field) int {
+	switch field.Name.Value {
+	case "images":
+		first := defaultPageSize
+		for _, argument := range field.Arguments {
+			if argument.Name.Value == "first" {
+				first = m.intValue(argument.Value)
+			}
+		}
+		if first < 1 {
+			return 1
+		} else if first > maxPageSize {
+			return maxPageSize
+		}
+		return first
+	case "organisations", "members", "revisions":
+		return graphqlListSize
+	}
+	return 1
+}
+
+// Return the value of an int argument. The values that are unknown count as the largest page
+func (m *graphqlMeter) intValue(value ast.Value) int {
+	switch value := value.(type) {
+	case *ast.IntValue:
+		if n, err := strconv.Atoi(value.Value); err == nil {
+			return n
+		}
+	case *ast.Variable:
+		switch n := m.variables[value.Name.Value].(type) {
+		case float64:
+			return int(n)
+		case int:
+			return n
+		}
+	}
+	return maxPageSize
+}
@@ -0,0 +1,242 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+func TestMeasureGraphQLOperation(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		operationName  string
+		variables      map[string]interface{}
+		wantDepth      int
+		wantComplexity int
+	}{
+		{
+			name:           "single object",
+			query:          `{ image(uuid: "x") { uuid name } }`,
+			wantDepth:      2,
+			wantComplexity: 3,
+		},
+		{
+			name:           "default page size",
+			query:          `{ images { edges { node { uuid } } } }`,
+			wantDepth:      4,
+			wantComplexity: 1 + 3*defaultPageSize,
+		},
+		{
+			name:           "nested lists",
+			query:          `{ images(first: 10) { edges { node { uuid revisions { url } } } } }`,
+			wantDepth:      5,
+			wantComplexity: 1 + 10 + 10 + 2*10 + 10*graphqlListSize,
+		},
+		{
+			name:           "page size larger than the maximum",
+			query:          `{ images(first: 1000) { pageInfo { hasNextPage } } }`,
+			wantDepth:      3,
+			wantComplexity: 1 + 2*maxPageSize,
+		},
+		{
+			name:           "page size from a variable",
+			query:          `query($n: Int) { images(first: $n) { pageInfo { hasNextPage } } }`,
+			variables:      map[string]interface{}{"n": float64(5)},
+			wantDepth:      3,
+			wantComplexity: 1 + 2*5,
+		},
+		{
+			name:           "missing variable",
+			query:          `query($n: Int) { images(first: $n) { pageInfo { hasNextPage } } }`,
+			wantDepth:      3,
+			wantComplexity: 1 + 2*maxPageSize,
+		},
+		{
+			name: "fragments",
+			query: `{ organisations { ...org } } ` +
+				`fragment org on Organisation { name ... on Organisation { members { username } } }`,
+			wantDepth:      3,
+			wantComplexity: 1 + 2*graphqlListSize + graphqlListSize*graphqlListSize,
+		},
+		{
+			name:           "named operation",
+			query:          `query a { organisations { name } } query b { image(uuid: "x") { name } }`,
+			operationName:  "b",
+			wantDepth:      2,
+			wantComplexity: 2,
+		},
+		{
+			name:           "introspection",
+			query:          `{ __schema { types { name fields { name type { name ofType { name } } } } } }`,
+			wantDepth:      0,
+			wantComplexity: 0,
+		},
+		{
+			name:           "cycle between images and organisations",
+			query:          `{ image(uuid: "x") { organisation { images { edges { node { organisation { name } } } } } } }`,
+			wantDepth:      7,
+			wantComplexity: 3 + 4*defaultPageSize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := parser.Parse(parser.ParseParams{Source: tt.query})
+			if err != nil {
+				t.Fatalf("parsing the query: %v", err)
+			}
+			depth, complexity := measureGraphQLOperation(document, tt.operationName, tt.variables)
+			if depth != tt.wantDepth || complexity != tt.wantComplexity {
+				t.Errorf("got depth %d and complexity %d, want %d and %d", depth, complexity, tt.wantDepth,
+					tt.wantComplexity)
+			}
+		})
+	}
+}
+
+func TestHandlePostGraphQL(t *testing.T) {
+	handler := &Handler{}
+	handler.graphqlSchema = newGraphQLSchema(handler)
+
+	tests := []struct {
+		name       string
+		body       string
+		key        string
+		wantStatus int
+		// id of the error of the REST API responded with, if any
+		wantErrorID int32
+		// substrings of the GraphQL response
+		wantBody []string
+	}{
+		{
+			name:        "invalid JSON",
+			body:        `{"query":`,
+			key:         "token",
+			wantStatus:  http.StatusBadRequest,
+			wantErrorID: common.InvalidRequestBodyError.Id,
+		},
+		{
+			name:        "unknown property",
+			body:        `{"query": "{ __typename }", "extra": true}`,
+			key:         "token",
+			wantStatus:  http.StatusBadRequest,
+			wantErrorID: common.InvalidRequestBodyError.Id,
+		},
+		{
+			name:        "missing query",
+			body:        `{}`,
+			key:         "token",
+			wantStatus:  http.StatusBadRequest,
+			wantErrorID: common.InvalidRequestBodyError.Id,
+		},
+		{
+			name:        "body too large",
+			body:        `{"query": "{ __typename }", "operationName": "` + strings.Repeat("a", maxGraphQLRequestSize) + `"}`,
+			key:         "token",
+			wantStatus:  http.StatusBadRequest,
+			wantErrorID: common.InvalidRequestBodyError.Id,
+		},
+		{
+			name:        "missing key",
+			body:        `{"query": "{ __typename }"}`,
+			wantStatus:  http.StatusUnauthorized,
+			wantErrorID: common.MissingTokenError.Id,
+		},
+		{
+			name:       "typename",
+			body:       `{"query": "{ __typename }"}`,
+			key:        "token",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"data":{"__typename":"Query"}`},
+		},
+		{
+			name:       "introspection",
+			body:       `{"query": "{ __type(name: \"Image\") { fields { name } } }"}`,
+			key:        "token",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`{"name":"organisation"}`, `{"name":"revisions"}`},
+		},
+		{
+			name:       "syntax error",
+			body:       `{"query": "{ image("}`,
+			key:        "token",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"errors":[{"message":"Syntax Error`, `"locations":[{"line":1,"column":9}]`},
+		},
+		{
+			name:       "unknown field",
+			body:       `{"query": "{ unknown }"}`,
+			key:        "token",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`Cannot query field \"unknown\" on type \"Query\".`},
+		},
+		{
+			name:       "missing argument",
+			body:       `{"query": "{ image { uuid } }"}`,
+			key:        "token",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`argument \"uuid\" of type \"ID!\" is required but not provided.`},
+		},
+		{
+			name: "too deep",
+			body: `{"query": "{ image(uuid: \"x\") { organisation { images { edges { node { organisation { name } } } } } ` +
+				`} }"}`,
+			key:         "token",
+			wantStatus:  http.StatusBadRequest,
+			wantErrorID: common.GraphQLQueryTooComplexError.Id,
+		},
+		{
+			name: "too complex",
+			body: `{"query": "{ images(first: 100) { edges { node { revisions { url size height length createdAt } } } ` +
+				`} }"}`,
+			key:         "token",
+			wantStatus:  http.StatusBadRequest,
+			wantErrorID: common.GraphQLQueryTooComplexError.Id,
+		},
+		{
+			name:       "invalid uuid",
+			body:       `{"query": "{ image(uuid: \"x\") { uuid } }"}`,
+			key:        "token",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"data":{"image":null}`, `"path":["image"]`, `"name":"InvalidUUIDError"`},
+		},
+		{
+			name:       "invalid token",
+			body:       `{"query": "{ image(uuid: \"4bdc5ee4-8d31-4e11-9d48-cc4e0bcf5a4b\") { uuid } }"}`,
+			key:        "token",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"data":{"image":null}`, `"name":"InvalidTokenError"`, `"id":1208`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body))
+			if tt.key != "" {
+				r.Header.Set("Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			handler.HandlePostGraphQL(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantErrorID != 0 {
+				var response common.ErrorResponse
+				err := json.Unmarshal(w.Body.Bytes(), &response)
+				if err != nil || response.Error_ == nil || response.Error_.Id != tt.wantErrorID {
+					t.Errorf("got %s, want the error %d", w.Body.String(), tt.wantErrorID)
+				}
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("got %s, want it to contain %s", w.Body.String(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+	"github.com/wtrep/shopify-backend-challenge-image/imagepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Content type of the gRPC responses recorded for an idempotency key
+const grpcIdempotentContentType = "application/grpc+proto"
+
+type grpcRoute struct {
+	name string
+	key  string
+}
+
+// REST route whose rate limit bucket each gRPC method shares so that a client can't get fresh buckets by switching
+// API. The other methods share the default bucket
+var grpcRateLimitRoutes = map[string]grpcRoute{
+	"/image.ImageService/GetImage":    {name: "signedURL", key: "GET /image/{uuid}"},
+	"/image.ImageService/UploadImage": {name: "upload", key: "POST /upload/{uuid}"},
+}
+
+// Scope authenticating the user of the unary methods accepting an idempotency-key metadata, along with the message
+// their responses are decoded into when they are replayed
+var idempotentGRPCMethods = map[string]struct {
+	scope    string
+	response func() proto.Message
+}{
+	"/image.ImageService/CreateImage": {scope: ScopeImagesWrite, response: func() proto.Message {
+		return &imagepb.Image{}
+	}},
+}
+
+// ServerStream whose context can be replaced
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// Keep the authentication of the call on its context and apply the rate limit of the method
+func (s *grpcServer) unaryRateLimitInterceptor(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = withAuthentication(ctx)
+	if err := s.takeRateLimit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, request)
+}
+
+// Keep the authentication of the stream on its context and apply the rate limit of the method
+func (s *grpcServer) streamRateLimitInterceptor(server interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := withAuthentication(stream.Context())
+	if err := s.takeRateLimit(ctx, info.FullMethod); err != nil {
+		return err
+	}
+	return handler(server, &contextServerStream{ServerStream: stream, ctx: ctx})
+}
+
+// Take a token from the bucket of the method for the authenticated user, falling back on the peer IP. The
+// RateLimitResult is sent in the header metadata
+func (s *grpcServer) takeRateLimit(ctx context.Context, method string) error {
+	route := grpcRateLimitRoutes[method]
+	bucket := rateLimitBucketOf(route.name, route.key)
+	if bucket.limit.Requests == 0 {
+		return nil
+	}
+
+	result, err := s.handler.rateLimits.Take(bucket.key+":"+s.rateLimitIdentity(ctx), bucket.limit, 1)
+	if err != nil {
+		// The store being unavailable shouldn't make the whole service unavailable
+		log.Println(err.Error())
+		return nil
+	}
+
+	header := metadata.Pairs(
+		"ratelimit-limit", strconv.Itoa(result.Limit),
+		"ratelimit-remaining", strconv.Itoa(result.Remaining),
+		"ratelimit-reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+	if !result.Allowed {
+		header.Set("retry-after", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	}
+	if err = grpc.SetHeader(ctx, header); err != nil {
+		log.Println(err.Error())
+	}
+	if !result.Allowed {
+		return grpcError(&common.TooManyRequestsError)
+	}
+	return nil
+}
+
+// Return the identity the call is limited by: the authenticated username or the peer IP
+func (s *grpcServer) rateLimitIdentity(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get("key"); len(keys) > 0 {
+		if username, _, errResponse := s.handler.identifyOnce(ctx, keys[0]); errResponse == nil {
+			return "user:" + username
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "ip:" + host
+		}
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:unknown"
+}
+
+// Replay the response of the first successful call made with the same idempotency-key metadata by the same user,
+// like the Idempotency-Key header of the REST API. The key is released when the call fails so that it can be retried
+func (s *grpcServer) unaryIdempotencyInterceptor(ctx context.Context, request interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method, ok := idempotentGRPCMethods[info.FullMethod]
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get("idempotency-key")
+	message, isMessage := request.(proto.Message)
+	if !ok || len(keys) == 0 || !isMessage {
+		return handler(ctx, request)
+	}
+	key := keys[0]
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return nil, grpcError(&common.InvalidIdempotencyKeyError)
+	}
+	// The method responds to the calls that aren't authenticated
+	username, err := s.authenticate(ctx, method.scope)
+	if err != nil {
+		return handler(ctx, request)
+	}
+
+	body, err := proto.Marshal(message)
+	if err != nil {
+		return handler(ctx, request)
+	}
+	hash := sha256.Sum256(append([]byte(info.FullMethod+"\n"), body...))
+	fingerprint := hex.EncodeToString(hash[:])
+
+	db := s.handler.db
+	reserved, err := ReserveIdempotencyKey(db, username, key, fingerprint, time.Now().UTC(),
+		s.handler.idempotencyRetention)
+	if err != nil {
+		return nil, grpcError(&common.IdempotencyDBError)
+	}
+	if !reserved {
+		return replayIdempotentCall(db, username, key, fingerprint, method.response())
+	}
+
+	response, callErr := handler(ctx, request)
+	if callErr != nil {
+		err = ReleaseIdempotencyKey(db, username, key)
+		if err != nil {
+			log.Printf("releasing the idempotency key %q of %s: %v", key, username, err)
+		}
+		return response, callErr
+	}
+	if encoded, marshalErr := proto.Marshal(response.(proto.Message)); marshalErr == nil {
+		err = CompleteIdempotencyKey(db, username, key, http.StatusOK, grpcIdempotentContentType, encoded)
+	} else {
+		err = ReleaseIdempotencyKey(db, username, key)
+	}
+	if err != nil {
+		log.Printf("recording the response of the idempotency key %q of %s: %v", key, username, err)
+	}
+	return response, nil
+}
+
+// Return the response recorded for the key decoded into the message, or an error if the key was used for another
+// call or if the first call is still being handled
+func replayIdempotentCall(db *sql.DB, username, key, fingerprint string, response proto.Message) (interface{},
+	error) {
+	recorded, err := GetIdempotentResponse(db, username, key)
+	switch {
+	case err != nil:
+		return nil, grpcError(&common.IdempotencyDBError)
+	case recorded.Fingerprint != fingerprint:
+		return nil, grpcError(&common.IdempotencyKeyConflictError)
+	case recorded.Status == 0:
+		return nil, grpcError(&common.IdempotencyKeyInProgressError)
+	case recorded.ContentType != grpcIdempotentContentType:
+		return nil, grpcError(&common.IdempotencyKeyConflictError)
+	}
+	if err = proto.Unmarshal(recorded.Body, response); err != nil {
+		return nil, grpcError(&common.IdempotencyDBError)
+	}
+	return response, nil
+}
@@ -0,0 +1,324 @@
+package image
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+	"github.com/wtrep/shopify-backend-challenge-image/imagepb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultGRPCPort   = "9090"
+	downloadChunkSize = 64 << 10
+	maxUploadSize     = 10 << 20
+)
+
+// gRPC implementation of the image endpoints. Every method goes through the same service functions as the REST
+// handlers so both APIs share their business logic, authentication and errors
+type grpcServer struct {
+	imagepb.UnimplementedImageServiceServer
+	handler *Handler
+}
+
+// Serve the gRPC API on the GRPC_PORT port until the process exits
+func serveGRPC(handler *Handler) {
+	port, ok := os.LookupEnv("GRPC_PORT")
+	if !ok {
+		port = defaultGRPCPort
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		panic(err)
+	}
+
+	// The calls go through the same rate limits and idempotency keys as the REST requests
+	grpcHandler := &grpcServer{handler: handler}
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcHandler.unaryRateLimitInterceptor, grpcHandler.unaryIdempotencyInterceptor),
+		grpc.ChainStreamInterceptor(grpcHandler.streamRateLimitInterceptor),
+	}
+	// Without a certificate the API is served in plaintext and must be exposed through a proxy terminating TLS
+	certFile, keyFile := os.Getenv("GRPC_TLS_CERT"), os.Getenv("GRPC_TLS_KEY")
+	if certFile != "" || keyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+
+	server := grpc.NewServer(options...)
+	imagepb.RegisterImageServiceServer(server, grpcHandler)
+	err = server.Serve(listener)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Create the record of an image
+func (s *grpcServer) CreateImage(ctx context.Context, request *imagepb.CreateImageRequest) (*imagepb.Image, error) {
+	username, err := s.authenticate(ctx, ScopeImagesWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	image, errResponse := s.handler.createImage(username, CreateImageRequest{
+		Name:         request.Name,
+		Extension:    request.Extension,
+		Height:       request.Height,
+		Length:       request.Length,
+		Organisation: request.Organisation,
+	})
+	if errResponse != nil {
+		return nil, grpcError(errResponse)
+	}
+	return image.toImagepb(""), nil
+}
+
+// Get an uploaded image along with a temporary download link
+func (s *grpcServer) GetImage(ctx context.Context, request *imagepb.GetImageRequest) (*imagepb.Image, error) {
+	id, err := uuid.Parse(request.Uuid)
+	if err != nil {
+		return nil, grpcError(&common.InvalidUUIDError)
+	}
+	username, err := s.authenticate(ctx, ScopeImagesRead)
+	if err != nil {
+		return nil, err
+	}
+
+	image, url, errResponse := s.handler.getLinkedImage(username, id)
+	if errResponse != nil {
+		return nil, grpcError(errResponse)
+	}
+	return image.toImagepb(url), nil
+}
+
+// List the images of the user or of one of its organisations
+func (s *grpcServer) ListImages(ctx context.Context, request *imagepb.ListImagesRequest) (*imagepb.ListImagesResponse,
+	error) {
+	username, err := s.authenticate(ctx, ScopeImagesRead)
+	if err != nil {
+		return nil, err
+	}
+
+	images, errResponse := s.handler.listImages(username, request.Organisation)
+	if errResponse != nil {
+		return nil, grpcError(errResponse)
+	}
+
+	response := &imagepb.ListImagesResponse{Images: make([]*imagepb.Image, 0)}
+	for _, image := range images {
+		response.Images = append(response.Images, image.toImagepb(""))
+	}
+	return response, nil
+}
+
+// Move an image to the trash
+func (s *grpcServer) DeleteImage(ctx context.Context, request *imagepb.DeleteImageRequest) (*imagepb.Image, error) {
+	id, err := uuid.Parse(request.Uuid)
+	if err != nil {
+		return nil, grpcError(&common.InvalidUUIDError)
+	}
+	username, err := s.authenticate(ctx, ScopeImagesDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	image, errResponse := s.handler.trashImage(username, id, "")
+	if errResponse != nil {
+		return nil, grpcError(errResponse)
+	}
+	return image.toImagepb(""), nil
+}
+
+// Upload the file of an image. The chunks are spooled to a temporary file since the file is read again to be
+// processed once it is stored
+func (s *grpcServer) UploadImage(stream imagepb.ImageService_UploadImageServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(first.Uuid)
+	if err != nil {
+		return grpcError(&common.InvalidUUIDError)
+	}
+	username, err := s.authenticate(stream.Context(), ScopeImagesWrite)
+	if err != nil {
+		return err
+	}
+	image, errResponse := s.handler.getEditableImage(username, id, &common.WrongUserError)
+	if errResponse != nil {
+		return grpcError(errResponse)
+	}
+
+	file, err := ioutil.TempFile("", "upload-")
+	if err != nil {
+		return grpcError(&common.FileUploadError)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	var size int64
+	for request := first; ; {
+		size += int64(len(request.Chunk))
+		if size > maxUploadSize {
+			return grpcError(&common.InvalidImageBodyError)
+		}
+		if _, err = file.Write(request.Chunk); err != nil {
+			return grpcError(&common.FileUploadError)
+		}
+
+		request, err = stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if size == 0 {
+		return grpcError(&common.InvalidImageBodyError)
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return grpcError(&common.FileUploadError)
+	}
+
+	errResponse = s.handler.uploadImage(image, file, size)
+	if errResponse != nil {
+		return grpcError(errResponse)
+	}
+	url, err := generateSignedURL(image.Bucket, image.BucketPath)
+	if err != nil {
+		return grpcError(&common.URLGenerationError)
+	}
+	return stream.SendAndClose(image.toImagepb(url))
+}
+
+// Download the file of an uploaded image in chunks streamed from the storage
+func (s *grpcServer) DownloadImage(request *imagepb.DownloadImageRequest,
+	stream imagepb.ImageService_DownloadImageServer) error {
+	id, err := uuid.Parse(request.Uuid)
+	if err != nil {
+		return grpcError(&common.InvalidUUIDError)
+	}
+	username, err := s.authenticate(stream.Context(), ScopeImagesRead)
+	if err != nil {
+		return err
+	}
+	image, errResponse := s.handler.getUploadedImage(username, id)
+	if errResponse != nil {
+		return grpcError(errResponse)
+	}
+
+	object, err := openObject(image.Bucket, image.BucketPath)
+	if err != nil {
+		return grpcError(&common.FileDownloadError)
+	}
+	defer object.Close()
+
+	chunk := make([]byte, downloadChunkSize)
+	for {
+		n, err := object.Read(chunk)
+		if n > 0 {
+			sendErr := stream.Send(&imagepb.DownloadImageResponse{Chunk: chunk[:n]})
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return grpcError(&common.FileDownloadError)
+		}
+	}
+}
+
+// Authenticate the JWT or API key sent in the key metadata
+func (s *grpcServer) authenticate(ctx context.Context, scope string) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get("key")
+	if len(keys) == 0 {
+		return "", grpcError(&common.MissingTokenError)
+	}
+
+	username, errResponse := s.handler.authenticateToken(ctx, keys[0], scope)
+	if errResponse != nil {
+		return "", grpcError(errResponse)
+	}
+	return username, nil
+}
+
+// Convert an error of the REST API into a gRPC status. The id and name of the error are kept in an ErrorInfo detail
+func grpcError(errResponse *common.ErrorResponseError) error {
+	st := status.New(grpcCode(errResponse.Code), errResponse.Detail)
+	details := []proto.Message{&errdetails.ErrorInfo{
+		Reason:   errResponse.Name,
+		Domain:   "image",
+		Metadata: map[string]string{"id": strconv.Itoa(int(errResponse.Id))},
+	}}
+	if len(errResponse.InvalidParams) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, param := range errResponse.InvalidParams {
+			badRequest.FieldViolations = append(badRequest.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{Field: param.Name, Description: param.Reason})
+		}
+		details = append(details, badRequest)
+	}
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// Return the gRPC code equivalent to the HTTP status of an error
+func grpcCode(httpStatus int32) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusInternalServerError:
+		return codes.Internal
+	default:
+		return codes.Unknown
+	}
+}
+
+// Convert an Image into an imagepb.Image message
+func (i Image) toImagepb(url string) *imagepb.Image {
+	return &imagepb.Image{
+		Uuid:          i.UUID.String(),
+		Name:          i.Name,
+		Url:           url,
+		Owner:         i.Owner,
+		Extension:     i.Extension,
+		Height:        i.Height,
+		Length:        i.Length,
+		Organisation:  i.organisationString(),
+		Status:        string(i.Status),
+		FailureReason: i.FailureReason,
+		Revision:      int32(i.Revision),
+	}
+}
@@ -1,25 +1,38 @@
 package image
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
 	"github.com/wtrep/shopify-backend-challenge-image/common"
 )
 
-const (
-	tokenLifetime = 24 * time.Hour
-)
-
 type Handler struct {
-	db *sql.DB
+	db                   *sql.DB
+	revocations          *revocationCache
+	quota                Quota
+	rateLimits           RateLimitStore
+	reaper               *StorageReaper
+	trashRetention       time.Duration
+	revisionRetention    int
+	idempotencyRetention time.Duration
+	accountJobs          *AccountJobRunner
+	webhooks             *WebhookDispatcher
+	events               *EventBroker
+	graphqlSchema        graphql.Schema
+	openapi              *openapiDocument
 }
 
 // Setup the routes and handle them
@@ -30,21 +43,112 @@ func SetupAndServeRoutes() {
 	if err != nil {
 		panic(err)
 	}
-	handler := Handler{db: db}
-
-	r := mux.NewRouter()
-	r.HandleFunc("/image", handler.HandlePostImage).Methods("POST")
-	r.HandleFunc("/image/{uuid}", handler.HandleGetImage).Methods("GET")
-	r.HandleFunc("/image/{uuid}", handler.HandleDeleteImage).Methods("DELETE")
-	r.HandleFunc("/images", handler.HandleGetImages).Methods("GET")
-	r.HandleFunc("/upload/{uuid}", handler.HandlePostUpload).Methods("POST")
-	r.HandleFunc("/healthz", HandleHealthzProbe)
+	handler := Handler{
+		db:                   db,
+		revocations:          newRevocationCache(revocationCacheTTL),
+		quota:                QuotaFromEnv(),
+		rateLimits:           NewMemoryRateLimitStore(),
+		reaper:               NewStorageReaper(db),
+		trashRetention:       parseDurationVariable("TRASH_RETENTION", defaultTrashRetention),
+		revisionRetention:    revisionRetentionFromEnv(),
+		idempotencyRetention: parseDurationVariable("IDEMPOTENCY_KEY_RETENTION", defaultIdempotencyRetention),
+		webhooks:             NewWebhookDispatcher(db),
+		events:               NewEventBroker(),
+	}
+	handler.accountJobs = NewAccountJobRunner(db, handler.reaper)
+	handler.graphqlSchema = newGraphQLSchema(&handler)
+	go handler.revocations.Run()
+	go handler.reaper.Run()
+	go handler.accountJobs.Run()
+	go handler.webhooks.Run()
+	go RunEventPruner(db, parseDurationVariable("EVENT_RETENTION", defaultEventRetention))
+	go RunIdempotencyPruner(db, handler.idempotencyRetention)
+	go serveGRPC(&handler)
+	go NewTrashPurger(db, handler.reaper, handler.trashRetention).Run()
+	if interval, options := ReconcileFromEnv(); interval > 0 {
+		go RunPeriodicReconcile(db, os.Getenv("BUCKET"), interval, options)
+	}
+
+	r, err := newRouter(&handler)
+	if err != nil {
+		panic(err)
+	}
 	err = http.ListenAndServe(":8080", r)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// Register the routes of the REST API along with the OpenAPI document describing them
+func newRouter(h *Handler) (*mux.Router, error) {
+	r := mux.NewRouter()
+	// The unprefixed paths are kept for the clients written before the API was versioned and behave like v1
+	registerRoutes(r, h)
+	registerRoutes(r.PathPrefix("/v1").Subrouter(), h)
+	// The routes of v2 that differ from v1 are registered first so that they take precedence
+	v2 := r.PathPrefix("/v2").Subrouter()
+	v2.HandleFunc("/images", h.HandleGetImagesPage).Methods("GET")
+	registerRoutes(v2, h)
+	r.HandleFunc("/openapi.json", h.HandleGetOpenAPI).Methods("GET")
+	r.HandleFunc(common.ProblemTypePath+"{id}", HandleGetProblemType).Methods("GET")
+	r.HandleFunc("/healthz", HandleHealthzProbe).Name("healthz")
+
+	openapi, err := newOpenAPIDocument(r)
+	if err != nil {
+		return nil, err
+	}
+	h.openapi = openapi
+	r.Use(requestIDMiddleware)
+	r.Use(authenticationMiddleware)
+	r.Use(problemMiddleware)
+	r.Use(h.rateLimitMiddleware)
+	r.Use(h.validationMiddleware)
+	r.Use(h.idempotencyMiddleware)
+	return r, nil
+}
+
+// Register the routes shared by every version of the API on the router
+func registerRoutes(r *mux.Router, h *Handler) {
+	r.HandleFunc("/image", h.HandlePostImage).Methods("POST")
+	r.HandleFunc("/image/{uuid}", h.HandleGetImage).Methods("GET").Name("signedURL")
+	r.HandleFunc("/image/{uuid}", h.HandlePatchImage).Methods("PATCH")
+	r.HandleFunc("/image/{uuid}", h.HandleDeleteImage).Methods("DELETE")
+	r.HandleFunc("/image/{uuid}/restore", h.HandlePostRestore).Methods("POST")
+	r.HandleFunc("/image/{uuid}/revisions", h.HandleGetRevisions).Methods("GET")
+	r.HandleFunc("/image/{uuid}/revision/{revision}", h.HandleGetRevision).Methods("GET").Name("signedURL")
+	r.HandleFunc("/image/{uuid}/revision/{revision}/restore", h.HandlePostRevisionRestore).Methods("POST").
+		Name("upload")
+	r.HandleFunc("/images", h.HandleGetImages).Methods("GET")
+	r.HandleFunc("/images/archive", h.HandlePostArchive).Methods("POST").Name("upload")
+	r.HandleFunc("/images/archive", h.HandleGetArchive).Methods("GET")
+	r.HandleFunc("/images/batch/create", h.HandleBatchCreateImages).Methods("POST")
+	r.HandleFunc("/images/batch/get", h.HandleBatchGetImages).Methods("POST").Name("signedURL")
+	r.HandleFunc("/images/batch/delete", h.HandleBatchDeleteImages).Methods("POST")
+	r.HandleFunc("/trash", h.HandleGetTrash).Methods("GET")
+	r.HandleFunc("/trash/{uuid}", h.HandleDeleteTrashedImage).Methods("DELETE")
+	r.HandleFunc("/upload/{uuid}", h.HandlePostUpload).Methods("POST").Name("upload")
+	r.HandleFunc("/usage", h.HandleGetUsage).Methods("GET")
+	r.HandleFunc("/events", h.HandleGetEvents).Methods("GET")
+	r.HandleFunc("/graphql", h.HandlePostGraphQL).Methods("POST")
+	r.HandleFunc("/apikey", h.HandlePostAPIKey).Methods("POST")
+	r.HandleFunc("/apikeys", h.HandleGetAPIKeys).Methods("GET")
+	r.HandleFunc("/apikey/{uuid}", h.HandleDeleteAPIKey).Methods("DELETE")
+	r.HandleFunc("/webhook", h.HandlePostWebhook).Methods("POST")
+	r.HandleFunc("/webhooks", h.HandleGetWebhooks).Methods("GET")
+	r.HandleFunc("/webhook/{uuid}", h.HandleDeleteWebhook).Methods("DELETE")
+	r.HandleFunc("/webhook/{uuid}/deliveries", h.HandleGetWebhookDeliveries).Methods("GET")
+	r.HandleFunc("/webhook/{uuid}/delivery/{delivery}/redeliver", h.HandlePostRedeliver).Methods("POST")
+	r.HandleFunc("/admin/revocation", h.HandlePostRevocation).Methods("POST")
+	r.HandleFunc("/admin/user/{username}/export", h.HandlePostAccountExport).Methods("POST")
+	r.HandleFunc("/admin/user/{username}/erasure", h.HandlePostAccountErasure).Methods("POST")
+	r.HandleFunc("/admin/job/{uuid}", h.HandleGetAccountJob).Methods("GET")
+	r.HandleFunc("/organisation", h.HandlePostOrganisation).Methods("POST")
+	r.HandleFunc("/organisations", h.HandleGetOrganisations).Methods("GET")
+	r.HandleFunc("/organisation/{uuid}", h.HandleGetOrganisation).Methods("GET")
+	r.HandleFunc("/organisation/{uuid}/member/{username}", h.HandlePutMember).Methods("PUT")
+	r.HandleFunc("/organisation/{uuid}/member/{username}", h.HandleDeleteMember).Methods("DELETE")
+}
+
 // Ensure that all required environment variables are set
 func CheckEnvVariables() {
 	env := []string{"DB_PASSWORD", "DB_USERNAME", "DB_NAME", "JWT_KEY", "BUCKET",
@@ -67,26 +171,25 @@ func HandleHealthzProbe(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) HandlePostImage(w http.ResponseWriter, r *http.Request) {
 	var request CreateImageRequest
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewDecoder(r.Body).Decode(&request)
-	if err != nil {
-		common.RespondWithError(w, &common.InvalidUUIDError)
+	errResponse := decodeStrictJSON(r.Body, &request)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	username, errResponse := handleJWT(r)
+	username, errResponse := h.authenticate(r, ScopeImagesWrite)
 	if errResponse != nil {
 		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	image := request.toImage(username)
-	err = CreateImage(h.db, image)
-	if err != nil {
-		common.RespondWithError(w, &common.DatabaseInsertionError)
+	image, errResponse := h.createImage(username, request)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(image.toCreateImageResponse())
+	err := json.NewEncoder(w).Encode(image.toCreateImageResponse())
 	if err != nil {
 		common.RespondWithError(w, &common.JSONEncoderError)
 	}
@@ -102,34 +205,37 @@ func (h *Handler) HandleGetImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username, errResponse := handleJWT(r)
+	username, errResponse := h.authenticate(r, ScopeImagesRead)
 	if errResponse != nil {
 		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	image, err := GetImage(h.db, uuidToGet)
+	image, errResponse := h.getUploadedImage(username, uuidToGet)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	// The download link is only generated if the client doesn't already have the current version of the image along
+	// with a link that is still valid
+	now := time.Now()
+	setLinkedCacheControl(w, now)
+	if respondNotModified(w, r, linkedImageETag(*image, now)) {
+		return
+	}
+	url, err := generateSignedURL(image.Bucket, image.BucketPath)
 	if err != nil {
-		common.RespondWithError(w, &common.ImageNotFoundError)
+		// The error must not be cached nor revalidated as if it were the image
+		w.Header().Del("Cache-Control")
+		w.Header().Del("ETag")
+		common.RespondWithError(w, &common.URLGenerationError)
 		return
 	}
 
-	if username == image.Owner && image.Status == "UPLOADED" {
-		url, err := generateSignedURL(image.Bucket, image.BucketPath)
-		if err != nil {
-			common.RespondWithError(w, &common.URLGenerationError)
-			return
-		}
-
-		response := image.toLinkedImageResponse(url)
-		err = json.NewEncoder(w).Encode(&response)
-		if err != nil {
-			common.RespondWithError(w, &common.JSONEncoderError)
-		}
-	} else if image.Status == "CREATED" {
-		common.RespondWithError(w, &common.ImageNotUploadedError)
-	} else {
-		common.RespondWithError(w, &common.UserPermissionDeniedError)
+	response := image.toLinkedImageResponse(url)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
 	}
 }
 
@@ -143,45 +249,34 @@ func (h *Handler) HandlePostUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	image, err := GetImage(h.db, uuidToUpload)
-	if err != nil {
-		common.RespondWithError(w, &common.ImageNotFoundError)
-		return
-	}
-
-	username, detailedErr := handleJWT(r)
+	username, detailedErr := h.authenticate(r, ScopeImagesWrite)
 	if detailedErr != nil {
 		common.RespondWithError(w, detailedErr)
 		return
 	}
-	if image.Owner != username {
-		common.RespondWithError(w, &common.WrongUserError)
+	image, detailedErr := h.getEditableImage(username, uuidToUpload, &common.WrongUserError)
+	if detailedErr != nil {
+		common.RespondWithError(w, detailedErr)
 		return
 	}
 
-	file, detailedErr := getImageFromForm(w, r)
+	file, size, detailedErr := getImageFromForm(w, r)
 	if detailedErr != nil {
 		common.RespondWithError(w, detailedErr)
 		return
 	}
 	defer file.Close()
 
-	err = uploadToBucket(file, image.Bucket, image.BucketPath)
-	if err != nil {
-		common.RespondWithError(w, &common.FileUploadError)
-		return
-	}
-
-	image.Status = "UPLOADED"
-	err = UpdateImage(h.db, *image)
-	if err != nil {
-		common.RespondWithError(w, &common.DatabaseInsertionError)
+	errResponse := h.uploadImage(image, file, size)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
 		return
 	}
 
 	url, err := generateSignedURL(image.Bucket, image.BucketPath)
 	if err != nil {
 		common.RespondWithError(w, &common.URLGenerationError)
+		return
 	}
 
 	response := image.toLinkedImageResponse(url)
@@ -191,108 +286,410 @@ func (h *Handler) HandlePostUpload(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Handle the API request to delete an image
-func (h *Handler) HandleDeleteImage(w http.ResponseWriter, r *http.Request) {
+// Handle the API request to change the name of an image
+func (h *Handler) HandlePatchImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	w.Header().Set("Content-Type", "application/json")
-	uuidToGet, err := uuid.Parse(vars["uuid"])
+	uuidToUpdate, err := uuid.Parse(vars["uuid"])
 	if err != nil {
 		common.RespondWithError(w, &common.InvalidUUIDError)
 		return
 	}
-
-	username, errResponse := handleJWT(r)
+	var request UpdateImageRequest
+	errResponse := decodeStrictJSON(r.Body, &request)
 	if errResponse != nil {
 		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	image, err := GetImage(h.db, uuidToGet)
-	if err != nil {
-		common.RespondWithError(w, &common.ImageNotFoundError)
+	username, errResponse := h.authenticate(r, ScopeImagesWrite)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	if username != image.Owner {
-		common.RespondWithError(w, &common.UserPermissionDeniedError)
+	image, errResponse := h.updateImage(username, uuidToUpdate, request, r.Header.Get("If-Match"))
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	tx, err := DeleteImage(h.db, image.UUID)
+	w.Header().Set("ETag", imageETag(*image))
+	response := image.toUnlinkedImageResponse()
+	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		common.RespondWithError(w, &common.DBDeletionError)
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to delete an image by moving it to the trash
+func (h *Handler) HandleDeleteImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	uuidToGet, err := uuid.Parse(vars["uuid"])
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidUUIDError)
 		return
 	}
 
-	if image.Status == "UPLOADED" {
-		err = deleteFile(image.Bucket, image.BucketPath)
-		if err != nil {
-			tx.Rollback()
-			common.RespondWithError(w, &common.FileDeletionError)
-			return
-		}
+	username, errResponse := h.authenticate(r, ScopeImagesDelete)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		common.RespondWithError(w, &common.DBDeletionError)
+	image, errResponse := h.trashImage(username, uuidToGet, r.Header.Get("If-Match"))
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	response := image.toUnlinkedImageResponse()
+	w.Header().Set("ETag", imageETag(*image))
+	response := image.toTrashedImageResponse(h.trashRetention)
 	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
 		common.RespondWithError(w, &common.JSONEncoderError)
 	}
 }
 
-// Handle the API request to get all images owned by the user initiating the request
+// Handle the API request to get all images owned by the user initiating the request or by one of its organisations
 func (h *Handler) HandleGetImages(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	username, errResponse := handleJWT(r)
+	username, errResponse := h.authenticate(r, ScopeImagesRead)
 	if errResponse != nil {
 		common.RespondWithError(w, errResponse)
 		return
 	}
 
-	images, err := GetImages(h.db, username)
-	if err != nil {
-		common.RespondWithError(w, &common.GetImagesDBError)
+	images, errResponse := h.listImages(username, r.URL.Query().Get("organisation"))
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if respondNotModified(w, r, imagesETag(images, "")) {
 		return
 	}
 
 	response := imagesToUnlinkedImagesReponse(images)
-	err = json.NewEncoder(w).Encode(&response)
+	err := json.NewEncoder(w).Encode(&response)
 	if err != nil {
 		common.RespondWithError(w, &common.JSONEncoderError)
 	}
 }
 
-// Check the validity of the JWT and return the username related to the token
-func handleJWT(r *http.Request) (string, *common.ErrorResponseError) {
+// Handle the v2 API request to get a page of the images owned by the user or by one of its organisations. Unlike
+// v1, the images are always paginated and the cursor of the next page is returned in the envelope of the page
+func (h *Handler) HandleGetImagesPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, errResponse := h.authenticate(r, ScopeImagesRead)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, errResponse := pageSizeOf(query)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	images, hasNextPage, errResponse := h.listImagesPage(username, query.Get("organisation"), limit,
+		query.Get("after"))
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	response := ImagesPageResponse{Items: imagesToUnlinkedImagesReponse(images)}
+	if hasNextPage {
+		response.NextCursor = imageCursorOf(images[len(images)-1]).encode()
+	}
+	if respondNotModified(w, r, imagesETag(images, response.NextCursor)) {
+		return
+	}
+	err := json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Transition the image to the status and return the error to respond with if the transition isn't allowed
+func (h *Handler) transitionOrRespond(image *Image, to Status) *common.ErrorResponseError {
+	err := TransitionImage(h.db, image, to, "")
+	if err == ErrInvalidTransition {
+		return &common.ImageStatusConflictError
+	} else if err != nil {
+		return &common.DatabaseInsertionError
+	}
+	return nil
+}
+
+// Make the revision the current file of the image and wake the reaper up if older revisions were pruned
+func (h *Handler) completeUpload(image *Image, revision Revision) *common.ErrorResponseError {
+	pruned, err := CompleteUpload(h.db, image, revision, h.revisionRetention)
+	if err == ErrInvalidTransition {
+		return &common.ImageStatusConflictError
+	} else if err != nil {
+		return &common.DatabaseInsertionError
+	}
+	if pruned > 0 {
+		h.reaper.wake()
+	}
+	return nil
+}
+
+// Enqueue the deletion of the file a new revision of the image is about to be written to. The deletion is only due
+// once the upload is considered interrupted, unless the upload fails or CompleteUpload removes it
+func (h *Handler) reserveRevisionFile(image Image, bucketPath string) *common.ErrorResponseError {
+	err := EnqueueFileDeletion(h.db, image, bucketPath, time.Now().UTC().Add(defaultUploadTTL))
+	if err != nil {
+		return &common.DatabaseInsertionError
+	}
+	return nil
+}
+
+// Undo a failed upload of the image and delete the file it may have left at bucketPath. An image that was UPLOADED
+// goes back to its previous file, the others are marked as FAILED with the reason reported to the clients
+func (h *Handler) failUpload(image *Image, previous Image, bucketPath, reason string) {
+	err := ExpediteFileDeletion(h.db, *image, bucketPath)
+	if err != nil {
+		log.Printf("deleting the file %s/%s of a failed upload: %v", image.Bucket, bucketPath, err)
+	} else {
+		h.reaper.wake()
+	}
+
+	image.BucketPath = previous.BucketPath
+	image.Size = previous.Size
+	image.Height = previous.Height
+	image.Length = previous.Length
+	if previous.Status == StatusUploaded {
+		err = TransitionImage(h.db, image, StatusUploaded, "")
+	} else {
+		err = TransitionImage(h.db, image, StatusFailed, reason)
+	}
+	if err != nil {
+		log.Printf("undoing the failed upload of %s: %v", image.UUID, err)
+	}
+}
+
+// Check the validity of the JWT or API key and return the username related to it. API keys must grant the scope
+func (h *Handler) authenticate(r *http.Request, scope string) (string, *common.ErrorResponseError) {
 	if r.Header["Key"] == nil {
 		return "", &common.MissingTokenError
 	}
+	return h.authenticateToken(r.Context(), r.Header["Key"][0], scope)
+}
 
-	username, err := common.VerifyJWT(r.Header["Key"][0])
+// Check the validity of the JWT or API key sent by a client and return the username related to it. The outcome is
+// reused by the later calls made with the same context
+func (h *Handler) authenticateToken(ctx context.Context, token, scope string) (string, *common.ErrorResponseError) {
+	username, key, errResponse := h.identifyOnce(ctx, token)
+	if errResponse != nil {
+		return "", errResponse
+	}
+	if key != nil && !key.hasScope(scope) {
+		return "", &common.InsufficientScopeError
+	}
+	return username, nil
+}
+
+// Outcome of the authentication of the token of a request, computed once and shared by the middlewares and the
+// handler so that a request only looks up its API key or its revocations once
+type authentication struct {
+	once        sync.Once
+	username    string
+	key         *APIKey
+	errResponse *common.ErrorResponseError
+}
+
+type authenticationKey struct{}
+
+// Give every request a place to keep the outcome of the authentication of its token
+func authenticationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withAuthentication(r.Context())))
+	})
+}
+
+// Return a copy of the context keeping the outcome of the first authentication made with it
+func withAuthentication(ctx context.Context) context.Context {
+	return context.WithValue(ctx, authenticationKey{}, &authentication{})
+}
+
+// Return the user authenticated by the token of the request along with the API key used, which is nil for a JWT.
+// The scopes of the API key aren't checked
+func (h *Handler) identify(r *http.Request) (string, *APIKey, *common.ErrorResponseError) {
+	if r.Header["Key"] == nil {
+		return "", nil, &common.MissingTokenError
+	}
+	return h.identifyOnce(r.Context(), r.Header["Key"][0])
+}
+
+// Return the user authenticated by the token along with the API key used, reusing the outcome kept on the context by
+// a previous call
+func (h *Handler) identifyOnce(ctx context.Context, token string) (string, *APIKey, *common.ErrorResponseError) {
+	auth, ok := ctx.Value(authenticationKey{}).(*authentication)
+	if !ok {
+		return h.identifyToken(token)
+	}
+	auth.once.Do(func() {
+		auth.username, auth.key, auth.errResponse = h.identifyToken(token)
+	})
+	return auth.username, auth.key, auth.errResponse
+}
+
+// Return the user authenticated by the JWT or API key along with the API key, which is nil for a JWT
+func (h *Handler) identifyToken(token string) (string, *APIKey, *common.ErrorResponseError) {
+	if isAPIKey(token) {
+		key, errResponse := h.handleAPIKey(token)
+		if errResponse != nil {
+			return "", nil, errResponse
+		}
+		return key.Owner, key, nil
+	}
+	username, errResponse := h.handleJWT(token)
+	return username, nil, errResponse
+}
+
+// Check the validity of the JWT, ensure it wasn't revoked and return the username related to the token
+func (h *Handler) handleJWT(token string) (string, *common.ErrorResponseError) {
+	claims, err := common.ParseJWT(token)
 	if err != nil {
 		return "", &common.InvalidTokenError
 	}
+
+	if claims.ID != "" {
+		revoked, err := h.revocations.isRevoked(h.db, claims.ID)
+		if err != nil {
+			return "", &common.TokenRevocationDBError
+		}
+		if revoked {
+			return "", &common.TokenRevokedError
+		}
+	}
+
+	notBefore, err := h.revocations.watermark(h.db, claims.Subject)
+	if err != nil {
+		return "", &common.TokenRevocationDBError
+	}
+	if claims.IssuedAt.Before(notBefore) {
+		return "", &common.TokenRevokedError
+	}
+	return claims.Subject, nil
+}
+
+// Ensure the user has at least the required role on the image. The owner of a personal image has every permission
+// while the permissions on an organisation image are resolved through the membership of the user
+func (h *Handler) authorizeImage(username string, image *Image, required Role,
+	denied *common.ErrorResponseError) *common.ErrorResponseError {
+	if image.Organisation == nil {
+		if image.Owner != username {
+			return denied
+		}
+		return nil
+	}
+
+	role, err := GetMemberRole(h.db, *image.Organisation, username)
+	if err == sql.ErrNoRows {
+		return denied
+	}
+	if err != nil {
+		return &common.OrganisationDBError
+	}
+	if !role.includes(required) {
+		return denied
+	}
+	return nil
+}
+
+// Ensure the user is a member of the organisation with at least the required role
+func (h *Handler) authorizeOrganisation(username string, organisation uuid.UUID,
+	required Role) *common.ErrorResponseError {
+	role, err := GetMemberRole(h.db, organisation, username)
+	if err == sql.ErrNoRows {
+		return &common.OrganisationNotFoundError
+	}
+	if err != nil {
+		return &common.OrganisationDBError
+	}
+	if !role.includes(required) {
+		return &common.OrganisationPermissionDeniedError
+	}
+	return nil
+}
+
+// Check that the request is authenticated with the JWT of a user listed in the ADMIN_USERS environment variable
+func (h *Handler) authenticateAdmin(r *http.Request) (string, *common.ErrorResponseError) {
+	username, errResponse := h.authenticateUser(r)
+	if errResponse != nil {
+		return "", errResponse
+	}
+	if !isAdmin(username) {
+		return "", &common.AdminRequiredError
+	}
 	return username, nil
 }
 
-// Parse the multipart-form and return the file uploaded
-func getImageFromForm(w http.ResponseWriter, r *http.Request) (multipart.File, *common.ErrorResponseError) {
+// Return true if the user is listed in the comma separated ADMIN_USERS environment variable
+func isAdmin(username string) bool {
+	for _, admin := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if strings.TrimSpace(admin) == username && username != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the validity of the API key and return it
+func (h *Handler) handleAPIKey(token string) (*APIKey, *common.ErrorResponseError) {
+	key, err := GetActiveAPIKeyByHash(h.db, hashAPIKey(token))
+	if err != nil {
+		return nil, &common.InvalidAPIKeyError
+	}
+
+	err = TouchAPIKey(h.db, key.UUID)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return key, nil
+}
+
+// Parse the multipart-form and return the file uploaded along with its size
+func getImageFromForm(w http.ResponseWriter, r *http.Request) (multipart.File, int64, *common.ErrorResponseError) {
 	r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
 	err := r.ParseMultipartForm(10 << 20)
 	if err != nil {
-		return nil, &common.InvalidImageBodyError
+		return nil, 0, &common.InvalidImageBodyError
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		return nil, 0, &common.InvalidImageBodyError
+	}
+	return file, header.Size, nil
+}
+
+// Handle the API request to get the storage consumption and the quota of the authenticated user
+func (h *Handler) HandleGetUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, errResponse := h.authenticate(r, ScopeImagesRead)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	usage, err := GetUsage(h.db, username)
+	if err != nil {
+		common.RespondWithError(w, &common.UsageDBError)
+		return
 	}
 
-	file, _, err := r.FormFile("image")
+	response := usage.toUsageResponse(h.quota)
+	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		return nil, &common.InvalidImageBodyError
+		common.RespondWithError(w, &common.JSONEncoderError)
 	}
-	return file, nil
 }
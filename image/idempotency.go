@@ -0,0 +1,272 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+const (
+	defaultIdempotencyRetention = 24 * time.Hour
+	idempotencyPruneInterval    = time.Hour
+	// Time after which a request that never completed, because its replica stopped, no longer holds its key
+	idempotencyLockTimeout   = 5 * time.Minute
+	maxIdempotencyKeyLength  = 255
+	maxIdempotentRequestSize = 10 << 20
+)
+
+// Routes accepting an Idempotency-Key, by method and unprefixed path template, with the scope authenticating their
+// user. The responses holding a download link aren't stored since the link expires long before the key
+var idempotentRoutes = map[string]struct {
+	scope        string
+	hasSignedURL bool
+}{
+	"POST /image":               {scope: ScopeImagesWrite},
+	"POST /upload/{uuid}":       {scope: ScopeImagesWrite, hasSignedURL: true},
+	"POST /images/batch/create": {scope: ScopeImagesWrite},
+	"POST /images/batch/delete": {scope: ScopeImagesDelete},
+}
+
+type IdempotentResponse struct {
+	// Hash of the method, the path and the body of the request that used the key
+	Fingerprint string
+	// Status of the response, 0 while the request is still being handled
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// ResponseWriter keeping a copy of the status and the body written through it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// Return the wrapped ResponseWriter
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Replay the first response to the requests sent again with the same Idempotency-Key by the same user, so that a
+// client retrying a request after a network error doesn't create the image twice. The key is released if the first
+// request failed for a reason that can go away so that it can be retried
+func (h *Handler) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		route := mux.CurrentRoute(r)
+		if key == "" || route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		idempotentRoute, ok := idempotentRoutes[routeKey(r, route)]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			w.Header().Set("Content-Type", "application/json")
+			common.RespondWithError(w, &common.InvalidIdempotencyKeyError)
+			return
+		}
+		// The handler responds to the requests that aren't authenticated. The authentication of the request is
+		// reused by the handler
+		username, errResponse := h.authenticate(r, idempotentRoute.scope)
+		if errResponse != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxIdempotentRequestSize+1))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			common.RespondWithError(w, &common.InvalidRequestBodyError)
+			return
+		}
+		// The handler rejects the bodies that are too large, so the key isn't reserved for them
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		if len(body) > maxIdempotentRequestSize {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fingerprint := requestFingerprint(r, body)
+		reserved, err := ReserveIdempotencyKey(h.db, username, key, fingerprint, time.Now().UTC(),
+			h.idempotencyRetention)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			common.RespondWithError(w, &common.IdempotencyDBError)
+			return
+		}
+		if !reserved {
+			h.replayIdempotentResponse(w, username, key, fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if isTransientStatus(recorder.status) || (idempotentRoute.hasSignedURL && recorder.status < 300) {
+			err = ReleaseIdempotencyKey(h.db, username, key)
+		} else {
+			err = CompleteIdempotencyKey(h.db, username, key, recorder.status, w.Header().Get("Content-Type"),
+				recorder.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("recording the response of the idempotency key %q of %s: %v", key, username, err)
+		}
+	})
+}
+
+// Return true if a response with the status depends on a state that can change before the request is retried: the
+// server errors, the conflicts with the status of an image, the rate limits and the quotas and permissions
+func isTransientStatus(status int) bool {
+	switch status {
+	case http.StatusForbidden, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= http.StatusInternalServerError
+}
+
+// Respond with the response recorded for the key, or with an error if the key was used for another request or if
+// the first request is still being handled
+func (h *Handler) replayIdempotentResponse(w http.ResponseWriter, username, key, fingerprint string) {
+	response, err := GetIdempotentResponse(h.db, username, key)
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case err != nil:
+		common.RespondWithError(w, &common.IdempotencyDBError)
+	case response.Fingerprint != fingerprint:
+		common.RespondWithError(w, &common.IdempotencyKeyConflictError)
+	case response.Status == 0:
+		common.RespondWithError(w, &common.IdempotencyKeyInProgressError)
+	default:
+		w.Header().Set("Content-Type", response.ContentType)
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(response.Status)
+		_, err = w.Write(response.Body)
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// Return the hash of the method, the path and the body of the request. The parts of the multipart bodies are hashed
+// rather than the raw body since clients generate a new boundary every time they send the request
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		hash.Write(body)
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			// A malformed body is hashed as is, the handler rejects it anyway
+			if err != io.EOF {
+				hash.Write(body)
+			}
+			break
+		}
+		io.WriteString(hash, part.FormName()+"\n"+part.FileName()+"\n")
+		io.Copy(hash, part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Reserve the key of the user for the request with the fingerprint. false is returned if the key is already used
+// by a request made within the retention, or by a request still being handled within the lock timeout
+func ReserveIdempotencyKey(db *sql.DB, username, key, fingerprint string, now time.Time,
+	retention time.Duration) (bool, error) {
+	_, err := db.Exec("DELETE FROM idempotency_keys WHERE username = ? AND idempotencyKey = ? AND "+
+		"(createdAt < ? OR (status IS NULL AND createdAt < ?))", username, key, now.Add(-retention),
+		now.Add(-idempotencyLockTimeout))
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Exec("INSERT IGNORE INTO idempotency_keys (username, idempotencyKey, fingerprint, createdAt) "+
+		"VALUES (?, ?, ?, ?)", username, key, fingerprint, now)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// Return the response recorded for the key of the user
+func GetIdempotentResponse(db *sql.DB, username, key string) (*IdempotentResponse, error) {
+	var response IdempotentResponse
+	var status sql.NullInt64
+	err := db.QueryRow("SELECT fingerprint, status, contentType, body, createdAt FROM idempotency_keys "+
+		"WHERE username = ? AND idempotencyKey = ?", username, key).Scan(&response.Fingerprint, &status,
+		&response.ContentType, &response.Body, &response.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	response.Status = int(status.Int64)
+	return &response, nil
+}
+
+// Record the response to the request that reserved the key of the user
+func CompleteIdempotencyKey(db *sql.DB, username, key string, status int, contentType string, body []byte) error {
+	_, err := db.Exec("UPDATE idempotency_keys SET status = ?, contentType = ?, body = ? WHERE username = ? "+
+		"AND idempotencyKey = ?", status, contentType, body, username, key)
+	return err
+}
+
+// Release the key of the user so that the request can be made again
+func ReleaseIdempotencyKey(db *sql.DB, username, key string) error {
+	_, err := db.Exec("DELETE FROM idempotency_keys WHERE username = ? AND idempotencyKey = ?", username, key)
+	return err
+}
+
+// Delete the keys used before the time
+func PruneIdempotencyKeys(db *sql.DB, createdBefore time.Time) error {
+	_, err := db.Exec("DELETE FROM idempotency_keys WHERE createdAt < ?", createdBefore)
+	return err
+}
+
+// Delete the keys older than the retention periodically. This function never returns
+func RunIdempotencyPruner(db *sql.DB, retention time.Duration) {
+	ticker := time.NewTicker(idempotencyPruneInterval)
+	defer ticker.Stop()
+	for {
+		err := PruneIdempotencyKeys(db, time.Now().UTC().Add(-retention))
+		if err != nil {
+			log.Println(err.Error())
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,162 @@
+package image
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Return a multipart body holding the file with the boundary, along with its content type
+func multipartBody(t *testing.T, boundary, filename string, content []byte) ([]byte, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.SetBoundary(boundary); err != nil {
+		t.Fatalf("setting the boundary: %v", err)
+	}
+	part, err := form.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatalf("creating the part: %v", err)
+	}
+	part.Write(content)
+	form.Close()
+	return body.Bytes(), form.FormDataContentType()
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	type request struct {
+		method      string
+		path        string
+		contentType string
+		body        []byte
+	}
+	jsonRequest := func(method, path, body string) request {
+		return request{method: method, path: path, contentType: "application/json", body: []byte(body)}
+	}
+	uploadRequest := func(boundary, filename, content string) request {
+		body, contentType := multipartBody(t, boundary, filename, []byte(content))
+		return request{method: "POST", path: "/upload/id", contentType: contentType, body: body}
+	}
+
+	tests := []struct {
+		name      string
+		first     request
+		second    request
+		wantEqual bool
+	}{
+		{
+			name:      "same request",
+			first:     jsonRequest("POST", "/image", `{"name":"cat"}`),
+			second:    jsonRequest("POST", "/image", `{"name":"cat"}`),
+			wantEqual: true,
+		},
+		{
+			name:   "different body",
+			first:  jsonRequest("POST", "/image", `{"name":"cat"}`),
+			second: jsonRequest("POST", "/image", `{"name":"dog"}`),
+		},
+		{
+			name:   "different path",
+			first:  jsonRequest("POST", "/image", `{"name":"cat"}`),
+			second: jsonRequest("POST", "/v2/image", `{"name":"cat"}`),
+		},
+		{
+			name:   "different method",
+			first:  jsonRequest("POST", "/images/batch/delete", `{"uuids":[]}`),
+			second: jsonRequest("PUT", "/images/batch/delete", `{"uuids":[]}`),
+		},
+		{
+			name:      "multipart bodies with different boundaries",
+			first:     uploadRequest("boundary1", "cat.png", "content"),
+			second:    uploadRequest("boundary2", "cat.png", "content"),
+			wantEqual: true,
+		},
+		{
+			name:   "multipart bodies with different files",
+			first:  uploadRequest("boundary1", "cat.png", "content"),
+			second: uploadRequest("boundary1", "cat.png", "other content"),
+		},
+		{
+			name:   "multipart bodies with different file names",
+			first:  uploadRequest("boundary1", "cat.png", "content"),
+			second: uploadRequest("boundary1", "dog.png", "content"),
+		},
+		{
+			name: "malformed multipart bodies",
+			first: request{method: "POST", path: "/upload/id", contentType: "multipart/form-data; boundary=b",
+				body: []byte("--b\r\nnot a part")},
+			second: request{method: "POST", path: "/upload/id", contentType: "multipart/form-data; boundary=b",
+				body: []byte("--b\r\nanother part")},
+		},
+	}
+	fingerprint := func(r request) string {
+		httpRequest := httptest.NewRequest(r.method, r.path, bytes.NewReader(r.body))
+		httpRequest.Header.Set("Content-Type", r.contentType)
+		return requestFingerprint(httpRequest, r.body)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := fingerprint(tt.first), fingerprint(tt.second)
+			if (first == second) != tt.wantEqual {
+				t.Errorf("got %s and %s, want them to be equal: %v", first, second, tt.wantEqual)
+			}
+			if first != fingerprint(tt.first) {
+				t.Errorf("got a different fingerprint for the same request")
+			}
+		})
+	}
+}
+
+func TestIdempotentRoutes(t *testing.T) {
+	tests := []struct {
+		route            string
+		wantScope        string
+		wantHasSignedURL bool
+	}{
+		{route: "POST /image", wantScope: ScopeImagesWrite},
+		{route: "POST /upload/{uuid}", wantScope: ScopeImagesWrite, wantHasSignedURL: true},
+		{route: "POST /images/batch/create", wantScope: ScopeImagesWrite},
+		{route: "POST /images/batch/delete", wantScope: ScopeImagesDelete},
+		// Reading images has no side effect to protect and its signed URLs must not be replayed once expired
+		{route: "POST /images/batch/get"},
+		{route: "GET /images"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			route := idempotentRoutes[tt.route]
+			if route.scope != tt.wantScope || route.hasSignedURL != tt.wantHasSignedURL {
+				t.Errorf("got the scope %q and hasSignedURL %v, want %q and %v", route.scope, route.hasSignedURL,
+					tt.wantScope, tt.wantHasSignedURL)
+			}
+		})
+	}
+}
+
+func TestIsTransientStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusCreated},
+		{status: http.StatusMultiStatus},
+		{status: http.StatusBadRequest},
+		{status: http.StatusNotFound},
+		{status: http.StatusRequestEntityTooLarge},
+		{status: http.StatusUnprocessableEntity},
+		{status: http.StatusForbidden, want: true},
+		{status: http.StatusConflict, want: true},
+		{status: http.StatusTooManyRequests, want: true},
+		{status: http.StatusInternalServerError, want: true},
+		{status: http.StatusServiceUnavailable, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			if got := isTransientStatus(tt.status); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package image
 
 import (
+	"database/sql"
 	"github.com/google/uuid"
 	"os"
+	"time"
 )
 
 type Image struct {
@@ -14,59 +16,96 @@ type Image struct {
 	Length     int32
 	Bucket     string
 	BucketPath string
-	Status     string
+	Status     Status
+	// Organisation owning the image. Nil for the personal images of Owner
+	Organisation *uuid.UUID
+	// Size in bytes of the uploaded file
+	Size      int64
+	CreatedAt time.Time
+	// Reason of the failure when the status is FAILED
+	FailureReason string
+	// Time at which the image was moved to the trash
+	TrashedAt sql.NullTime
+	// Number of the current revision. 0 until the first upload
+	Revision int
+	// Incremented by every change of the record. The ETags of the image are derived from it
+	Version int
 }
 
 // Convert a CreateImageRequest into an Image object
-func (i CreateImageRequest) toImage(owner string) Image {
+func (i CreateImageRequest) toImage(owner string, organisation *uuid.UUID) Image {
 	uuidToCreate := uuid.New()
 	return Image{
-		UUID:       uuidToCreate,
-		Name:       i.Name,
-		Owner:      owner,
-		Extension:  i.Extension,
-		Height:     i.Height,
-		Length:     i.Length,
-		Bucket:     os.Getenv("BUCKET"),
-		BucketPath: uuidToCreate.String() + "." + i.Extension,
-		Status:     "CREATED",
+		UUID:         uuidToCreate,
+		Name:         i.Name,
+		Owner:        owner,
+		Extension:    i.Extension,
+		Height:       i.Height,
+		Length:       i.Length,
+		Bucket:       os.Getenv("BUCKET"),
+		BucketPath:   uuidToCreate.String() + "." + i.Extension,
+		Status:       StatusCreated,
+		Organisation: organisation,
+		CreatedAt:    time.Now().UTC(),
+		Version:      1,
 	}
 }
 
 // Convert an Image into a LinkedImageResponse object
 func (i Image) toLinkedImageResponse(url string) LinkedImageResponse {
 	return LinkedImageResponse{
-		Uuid:      i.UUID.String(),
-		Name:      i.Name,
-		Url:       url,
-		Owner:     i.Owner,
-		Extension: i.Extension,
-		Height:    i.Height,
-		Length:    i.Length,
+		Uuid:          i.UUID.String(),
+		Name:          i.Name,
+		Url:           url,
+		Owner:         i.Owner,
+		Extension:     i.Extension,
+		Height:        i.Height,
+		Length:        i.Length,
+		Organisation:  i.organisationString(),
+		Status:        string(i.Status),
+		FailureReason: i.FailureReason,
+		Revision:      i.Revision,
 	}
 }
 
 // Convert an Image into an UnlinkedImageResponse object
 func (i Image) toUnlinkedImageResponse() UnlinkedImageResponse {
 	return UnlinkedImageResponse{
-		Uuid:      i.UUID.String(),
-		Name:      i.Name,
-		Owner:     i.Owner,
-		Extension: i.Extension,
-		Height:    i.Height,
-		Length:    i.Length,
+		Uuid:          i.UUID.String(),
+		Name:          i.Name,
+		Owner:         i.Owner,
+		Extension:     i.Extension,
+		Height:        i.Height,
+		Length:        i.Length,
+		Organisation:  i.organisationString(),
+		Status:        string(i.Status),
+		FailureReason: i.FailureReason,
+		Revision:      i.Revision,
 	}
 }
 
+// Convert a trashed Image into an UnlinkedImageResponse object including the trash dates
+func (i Image) toTrashedImageResponse(retention time.Duration) UnlinkedImageResponse {
+	response := i.toUnlinkedImageResponse()
+	trashedAt := i.TrashedAt.Time
+	purgeAt := i.purgeAt(retention)
+	response.TrashedAt = &trashedAt
+	response.PurgeAt = &purgeAt
+	return response
+}
+
 // Convert an Image into a CreateImageResponse object
 func (i Image) toCreateImageResponse() CreateImageResponse {
 	return CreateImageResponse{
-		Uuid:      i.UUID.String(),
-		Name:      i.Name,
-		Owner:     i.Owner,
-		Extension: i.Extension,
-		Height:    i.Height,
-		Length:    i.Length,
+		Uuid:          i.UUID.String(),
+		Name:          i.Name,
+		Owner:         i.Owner,
+		Extension:     i.Extension,
+		Height:        i.Height,
+		Length:        i.Length,
+		Organisation:  i.organisationString(),
+		Status:        string(i.Status),
+		FailureReason: i.FailureReason,
 	}
 }
 
@@ -78,3 +117,11 @@ func imagesToUnlinkedImagesReponse(images []Image) []UnlinkedImageResponse {
 	}
 	return response
 }
+
+// Return the uuid of the organisation owning the image or an empty string for a personal image
+func (i Image) organisationString() string {
+	if i.Organisation == nil {
+		return ""
+	}
+	return i.Organisation.String()
+}
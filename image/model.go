@@ -1,5 +1,12 @@
 package image
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
 type CreateImageRequest struct {
 	// name of the image
 	Name string `json:"name,omitempty"`
@@ -7,6 +14,13 @@ type CreateImageRequest struct {
 	Extension string `json:"extension,omitempty"`
 	Height    int32  `json:"height,omitempty"`
 	Length    int32  `json:"length,omitempty"`
+	// uuid of the organisation owning the image
+	Organisation string `json:"organisation,omitempty"`
+}
+
+type UpdateImageRequest struct {
+	// new name of the image
+	Name string `json:"name,omitempty"`
 }
 
 type CreateImageResponse struct {
@@ -20,6 +34,12 @@ type CreateImageResponse struct {
 	Extension string `json:"extension,omitempty"`
 	Height    int32  `json:"height,omitempty"`
 	Length    int32  `json:"length,omitempty"`
+	// uuid of the organisation owning the image
+	Organisation string `json:"organisation,omitempty"`
+	// upload status of the image
+	Status string `json:"status,omitempty"`
+	// reason of the failure when the status is FAILED
+	FailureReason string `json:"failureReason,omitempty"`
 }
 
 type LinkedImageResponse struct {
@@ -35,6 +55,14 @@ type LinkedImageResponse struct {
 	Extension string `json:"extension,omitempty"`
 	Height    int32  `json:"height,omitempty"`
 	Length    int32  `json:"length,omitempty"`
+	// uuid of the organisation owning the image
+	Organisation string `json:"organisation,omitempty"`
+	// upload status of the image
+	Status string `json:"status,omitempty"`
+	// reason of the failure when the status is FAILED
+	FailureReason string `json:"failureReason,omitempty"`
+	// number of the current revision
+	Revision int `json:"revision,omitempty"`
 }
 
 type UnlinkedImageResponse struct {
@@ -48,6 +76,282 @@ type UnlinkedImageResponse struct {
 	Extension string `json:"extension,omitempty"`
 	Height    int32  `json:"height,omitempty"`
 	Length    int32  `json:"length,omitempty"`
+	// uuid of the organisation owning the image
+	Organisation string `json:"organisation,omitempty"`
+	// upload status of the image
+	Status string `json:"status,omitempty"`
+	// reason of the failure when the status is FAILED
+	FailureReason string `json:"failureReason,omitempty"`
+	// number of the current revision
+	Revision int `json:"revision,omitempty"`
+	// time at which the image was moved to the trash
+	TrashedAt *time.Time `json:"trashedAt,omitempty"`
+	// time at which the trashed image will be permanently deleted
+	PurgeAt *time.Time `json:"purgeAt,omitempty"`
 }
 
 type UnlinkedImagesResponse = []UnlinkedImageResponse
+
+type ImagesPageResponse struct {
+	// images of the page
+	Items []UnlinkedImageResponse `json:"items"`
+	// cursor to pass as the after query parameter to get the next page. Absent on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	// name given to the key to identify it
+	Name string `json:"name,omitempty"`
+	// scopes granted to the key. Every scope is granted when empty
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+type APIKeyResponse struct {
+	// unique id of the key
+	Uuid string `json:"uuid,omitempty"`
+	// name given to the key
+	Name string `json:"name,omitempty"`
+	// non secret prefix of the key
+	Prefix string `json:"prefix,omitempty"`
+	// scopes granted to the key
+	Scopes     []string   `json:"scopes,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	// clear value of the key. It is only returned once at creation
+	Key string `json:"key,omitempty"`
+}
+
+type APIKeysResponse = []APIKeyResponse
+
+type RevokeTokenRequest struct {
+	// jti of the token to revoke
+	Jti string `json:"jti,omitempty"`
+	// user whose tokens issued before issuedBefore are revoked
+	Username string `json:"username,omitempty"`
+	// defaults to the current time when username is set
+	IssuedBefore *time.Time `json:"issuedBefore,omitempty"`
+}
+
+type RevokeTokenResponse struct {
+	Jti          string     `json:"jti,omitempty"`
+	Username     string     `json:"username,omitempty"`
+	IssuedBefore *time.Time `json:"issuedBefore,omitempty"`
+}
+
+type CreateOrganisationRequest struct {
+	// name of the organisation
+	Name string `json:"name,omitempty"`
+}
+
+type SetMemberRequest struct {
+	// role of the member: viewer, editor or admin
+	Role string `json:"role,omitempty"`
+}
+
+type MemberResponse struct {
+	Username string `json:"username,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+type OrganisationResponse struct {
+	// unique id of the organisation
+	Uuid string `json:"uuid,omitempty"`
+	// name of the organisation
+	Name      string    `json:"name,omitempty"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// role of the authenticated user in the organisation
+	Role    string           `json:"role,omitempty"`
+	Members []MemberResponse `json:"members,omitempty"`
+}
+
+type OrganisationsResponse = []OrganisationResponse
+
+type UsageResponse struct {
+	// number of images owned
+	Objects int64 `json:"objects"`
+	// number of bytes stored
+	Bytes int64 `json:"bytes"`
+	// maximum number of images. Unlimited when absent
+	MaxObjects int64 `json:"maxObjects,omitempty"`
+	// maximum number of bytes. Unlimited when absent
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+type RevisionResponse struct {
+	// number of the revision
+	Revision int `json:"revision"`
+	// true for the revision currently served by the image
+	Current bool `json:"current"`
+	// url to the file of the revision
+	Url       string    `json:"url,omitempty"`
+	Size      int64     `json:"size"`
+	Height    int32     `json:"height,omitempty"`
+	Length    int32     `json:"length,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type RevisionsResponse = []RevisionResponse
+
+type BatchCreateImagesRequest struct {
+	// images to create. At most 100 per call
+	Images []CreateImageRequest `json:"images,omitempty"`
+}
+
+type BatchUUIDsRequest struct {
+	// uuids of the images. At most 100 per call
+	Uuids []string `json:"uuids,omitempty"`
+}
+
+type BatchCreateImageResult struct {
+	// position of the image in the request
+	Index int                        `json:"index"`
+	Image *CreateImageResponse       `json:"image,omitempty"`
+	Error *common.ErrorResponseError `json:"error,omitempty"`
+}
+
+type BatchLinkedImageResult struct {
+	Uuid  string                     `json:"uuid,omitempty"`
+	Image *LinkedImageResponse       `json:"image,omitempty"`
+	Error *common.ErrorResponseError `json:"error,omitempty"`
+}
+
+type BatchUnlinkedImageResult struct {
+	Uuid  string                     `json:"uuid,omitempty"`
+	Image *UnlinkedImageResponse     `json:"image,omitempty"`
+	Error *common.ErrorResponseError `json:"error,omitempty"`
+}
+
+type BatchCreateImagesResponse struct {
+	Results []BatchCreateImageResult `json:"results"`
+}
+
+type BatchGetImagesResponse struct {
+	Results []BatchLinkedImageResult `json:"results"`
+}
+
+type BatchDeleteImagesResponse struct {
+	Results []BatchUnlinkedImageResult `json:"results"`
+}
+
+type ArchiveEntryResult struct {
+	// path of the entry inside the archive
+	Name string `json:"name"`
+	// image created for the entry. It is also returned along with the error if the upload of the entry failed
+	Image *UnlinkedImageResponse     `json:"image,omitempty"`
+	Error *common.ErrorResponseError `json:"error,omitempty"`
+}
+
+type ArchiveResponse struct {
+	Results []ArchiveEntryResult `json:"results"`
+}
+
+type AccountJobResponse struct {
+	// unique id of the job
+	Uuid string `json:"uuid,omitempty"`
+	// export or erasure
+	Kind string `json:"kind,omitempty"`
+	// user whose data is exported or erased
+	Username string `json:"username,omitempty"`
+	// admin who requested the job
+	RequestedBy string `json:"requestedBy,omitempty"`
+	// PENDING, RUNNING, COMPLETED or FAILED
+	Status string `json:"status,omitempty"`
+	// number of images to process and number of images already processed
+	Total     int `json:"total"`
+	Processed int `json:"processed"`
+	// url to the archive of a completed export
+	Url string `json:"url,omitempty"`
+	// error that made the job fail
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+type CreateWebhookRequest struct {
+	// http or https url receiving the events
+	Url string `json:"url,omitempty"`
+	// events delivered to the webhook. Every event is delivered if empty
+	Events []string `json:"events,omitempty"`
+}
+
+type WebhookResponse struct {
+	// unique id of the webhook
+	Uuid string `json:"uuid,omitempty"`
+	// url receiving the events
+	Url string `json:"url,omitempty"`
+	// events delivered to the webhook. Every event is delivered if empty
+	Events    []string  `json:"events,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CreateWebhookResponse struct {
+	WebhookResponse
+	// secret signing the payloads. Only returned when the webhook is created
+	Secret string `json:"secret"`
+}
+
+type WebhookDeliveryResponse struct {
+	// id of the delivery, also sent in the X-Webhook-Delivery header
+	Id      int64           `json:"id,omitempty"`
+	Event   string          `json:"event,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// number of attempts made so far
+	Attempts int `json:"attempts"`
+	// HTTP status of the last attempt, 0 if no response was received
+	LastStatus int       `json:"lastStatus,omitempty"`
+	LastError  string    `json:"lastError,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// time of the next attempt of a pending delivery
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty"`
+	// time at which the delivery was abandoned after too many failed attempts
+	FailedAt *time.Time `json:"failedAt,omitempty"`
+}
+
+type WebhookDeliveriesResponse = []WebhookDeliveryResponse
+
+type EventPayload struct {
+	// id of the event in the event log, also used as the id of the Server-Sent Events
+	Id string `json:"id"`
+	// image.created, image.uploaded, image.updated or image.deleted
+	Type      string                `json:"type"`
+	CreatedAt time.Time             `json:"createdAt"`
+	Image     UnlinkedImageResponse `json:"image"`
+}
+
+type GraphQLRequest struct {
+	// GraphQL document containing the operation(s) to execute
+	Query string `json:"query"`
+	// name of the operation to execute when the document contains several operations
+	OperationName string `json:"operationName,omitempty"`
+	// values of the variables of the operation
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type GraphQLResponse struct {
+	// result of the operation, with null for the fields that failed
+	Data map[string]interface{} `json:"data,omitempty"`
+	// errors of the document or of the fields
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+type GraphQLError struct {
+	Message   string            `json:"message"`
+	Locations []GraphQLLocation `json:"locations,omitempty"`
+	// path of the field that failed
+	Path []interface{} `json:"path,omitempty"`
+	// id, name and code of the error of the REST API
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
@@ -0,0 +1,619 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+type openapiDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openapiInfo                             `json:"info"`
+	Security   []map[string][]string                   `json:"security"`
+	Paths      map[string]map[string]*openapiOperation `json:"paths"`
+	Components openapiComponents                       `json:"components"`
+	// Schemas of the JSON request bodies by method and path template, used to validate the requests
+	requestSchemas map[string]*openapiSchema
+}
+
+type openapiInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openapiComponents struct {
+	Schemas         map[string]*openapiSchema        `json:"schemas"`
+	Responses       map[string]*openapiResponse      `json:"responses"`
+	SecuritySchemes map[string]openapiSecurityScheme `json:"securitySchemes"`
+}
+
+type openapiSecurityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in"`
+	Name string `json:"name"`
+}
+
+type openapiOperation struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Parameters  []openapiParameter          `json:"parameters,omitempty"`
+	RequestBody *openapiRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openapiResponse `json:"responses"`
+	// Empty for the routes that don't require authentication
+	Security *[]map[string][]string `json:"security,omitempty"`
+}
+
+type openapiParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openapiSchema `json:"schema"`
+}
+
+type openapiRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openapiMediaType `json:"content"`
+}
+
+type openapiMediaType struct {
+	Schema   *openapiSchema            `json:"schema,omitempty"`
+	Examples map[string]openapiExample `json:"examples,omitempty"`
+}
+
+type openapiExample struct {
+	Summary string      `json:"summary"`
+	Value   interface{} `json:"value"`
+}
+
+type openapiResponse struct {
+	Ref         string                      `json:"$ref,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Content     map[string]openapiMediaType `json:"content,omitempty"`
+}
+
+type openapiSchema struct {
+	Ref        string                    `json:"$ref,omitempty"`
+	Type       string                    `json:"type,omitempty"`
+	Format     string                    `json:"format,omitempty"`
+	Nullable   bool                      `json:"nullable,omitempty"`
+	Items      *openapiSchema            `json:"items,omitempty"`
+	Properties map[string]*openapiSchema `json:"properties,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+	Enum       []string                  `json:"enum,omitempty"`
+	MinLength  int                       `json:"minLength,omitempty"`
+	MaxLength  int                       `json:"maxLength,omitempty"`
+	Minimum    *float64                  `json:"minimum,omitempty"`
+	Maximum    *float64                  `json:"maximum,omitempty"`
+	// Schema of the properties that aren't listed, or false if they are rejected
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+}
+
+// Documentation of a route that can't be read from the router
+type apiRoute struct {
+	summary string
+	// names of the optional query parameters
+	query []string
+	// names of the optional request headers
+	headers []string
+	// zero value of the JSON request body, nil if the route doesn't read one
+	request interface{}
+	// fields of the multipart form read by the route along with their format
+	form map[string]string
+	// status of the successful responses. 200 if not set
+	status int
+	// zero value of the response body. The body is any JSON value if nil
+	response interface{}
+	// content type of the response when it isn't JSON
+	contentType string
+	// true for the routes that don't require authentication
+	public bool
+}
+
+// Documentation of every route by method and path template. A route missing from this list is still documented
+// from the router but without its bodies
+var apiRoutes = map[string]apiRoute{
+	"POST /image": {summary: "Create the record of an image", request: CreateImageRequest{},
+		response: CreateImageResponse{}},
+	"GET /image/{uuid}": {summary: "Get an uploaded image along with a temporary download link",
+		headers: []string{"If-None-Match"}, response: LinkedImageResponse{}},
+	"PATCH /image/{uuid}": {summary: "Rename an image", headers: []string{"If-Match"},
+		request: UpdateImageRequest{}, response: UnlinkedImageResponse{}},
+	"DELETE /image/{uuid}": {summary: "Move an image to the trash", headers: []string{"If-Match"},
+		response: UnlinkedImageResponse{}},
+	"POST /image/{uuid}/restore":  {summary: "Restore a trashed image", response: UnlinkedImageResponse{}},
+	"GET /image/{uuid}/revisions": {summary: "List the revisions of an image", response: RevisionsResponse{}},
+	"GET /image/{uuid}/revision/{revision}": {summary: "Get a revision along with a temporary download link",
+		response: RevisionResponse{}},
+	"POST /image/{uuid}/revision/{revision}/restore": {summary: "Make a previous revision the current file",
+		response: LinkedImageResponse{}},
+	"GET /images": {summary: "List the personal images of the user or the images of an organisation",
+		query: []string{"organisation"}, headers: []string{"If-None-Match"},
+		response: UnlinkedImagesResponse{}},
+	"POST /images/archive": {summary: "Import the images of a ZIP archive",
+		form: map[string]string{"archive": "binary", "organisation": ""}, response: ArchiveResponse{}},
+	"GET /images/archive": {summary: "Download uploaded images as a ZIP archive", query: []string{"uuid"},
+		contentType: "application/zip"},
+	"POST /images/batch/create": {summary: "Create up to 100 image records", request: BatchCreateImagesRequest{},
+		response: BatchCreateImagesResponse{}},
+	"POST /images/batch/get": {summary: "Get up to 100 uploaded images", request: BatchUUIDsRequest{},
+		response: BatchGetImagesResponse{}},
+	"POST /images/batch/delete": {summary: "Move up to 100 images to the trash", request: BatchUUIDsRequest{},
+		response: BatchDeleteImagesResponse{}},
+	"GET /trash": {summary: "List the trashed images", query: []string{"organisation"},
+		response: UnlinkedImagesResponse{}},
+	"DELETE /trash/{uuid}": {summary: "Permanently delete a trashed image", response: UnlinkedImageResponse{}},
+	"POST /upload/{uuid}": {summary: "Upload the file of an image", form: map[string]string{"image": "binary"},
+		response: LinkedImageResponse{}},
+	"GET /usage": {summary: "Get the storage usage and the quota of the user", response: UsageResponse{}},
+	"GET /events": {summary: "Stream the events of the images of the user as Server-Sent Events",
+		query: []string{"lastEventId"}, response: EventPayload{}, contentType: "text/event-stream"},
+	"POST /graphql": {summary: "Execute a GraphQL operation", request: GraphQLRequest{},
+		response: GraphQLResponse{}},
+	"POST /apikey": {summary: "Create an API key", request: CreateAPIKeyRequest{}, status: http.StatusCreated,
+		response: CreateAPIKeyResponse{}},
+	"GET /apikeys":          {summary: "List the API keys of the user", response: APIKeysResponse{}},
+	"DELETE /apikey/{uuid}": {summary: "Revoke an API key", response: APIKeyResponse{}},
+	"POST /webhook": {summary: "Subscribe a URL to the events of the images", request: CreateWebhookRequest{},
+		status: http.StatusCreated, response: CreateWebhookResponse{}},
+	"GET /webhooks":          {summary: "List the webhooks of the user", response: []WebhookResponse{}},
+	"DELETE /webhook/{uuid}": {summary: "Delete a webhook", response: WebhookResponse{}},
+	"GET /webhook/{uuid}/deliveries": {summary: "List the recent deliveries of a webhook",
+		response: WebhookDeliveriesResponse{}},
+	"POST /webhook/{uuid}/delivery/{delivery}/redeliver": {summary: "Deliver an event again",
+		status: http.StatusAccepted, response: WebhookDeliveryResponse{}},
+	"POST /admin/revocation": {summary: "Revoke a token or every token of a user", request: RevokeTokenRequest{},
+		response: RevokeTokenResponse{}},
+	"POST /admin/user/{username}/export": {summary: "Export the images of a user", status: http.StatusAccepted,
+		response: AccountJobResponse{}},
+	"POST /admin/user/{username}/erasure": {summary: "Erase the data of a user", status: http.StatusAccepted,
+		response: AccountJobResponse{}},
+	"GET /admin/job/{uuid}": {summary: "Get the progress of an export or an erasure", response: AccountJobResponse{}},
+	"POST /organisation": {summary: "Create an organisation", request: CreateOrganisationRequest{},
+		status: http.StatusCreated, response: OrganisationResponse{}},
+	"GET /organisations": {summary: "List the organisations of the user", response: OrganisationsResponse{}},
+	"GET /organisation/{uuid}": {summary: "Get an organisation along with its members",
+		response: OrganisationResponse{}},
+	"PUT /organisation/{uuid}/member/{username}": {summary: "Add a member or change its role",
+		request: SetMemberRequest{}, response: MemberResponse{}},
+	"DELETE /organisation/{uuid}/member/{username}": {summary: "Remove a member", response: MemberResponse{}},
+	"GET /v2/images": {summary: "List a page of the images of the user or of an organisation",
+		query: []string{"organisation", "limit", "after"}, headers: []string{"If-None-Match"},
+		response: ImagesPageResponse{}},
+	"GET /healthz":      {summary: "Readiness and liveness probe", contentType: "text/plain", public: true},
+	"GET /openapi.json": {summary: "Get this OpenAPI document", public: true},
+	"GET /problems/{id}": {summary: "Describe the problem type of an error", response: common.Problem{},
+		public: true},
+}
+
+var pathParameter = regexp.MustCompile(`{([^}:]+)(:[^}]*)?}`)
+
+// Build the OpenAPI document of every route of the router along with the schemas of the models and every error
+func newOpenAPIDocument(router *mux.Router) (*openapiDocument, error) {
+	schemas := map[string]*openapiSchema{}
+	document := &openapiDocument{
+		OpenAPI:  "3.0.3",
+		Info:     openapiInfo{Title: "Image microservice", Version: "1.0.0"},
+		Security: []map[string][]string{{"Key": {}}},
+		Paths:    map[string]map[string]*openapiOperation{},
+		Components: openapiComponents{
+			Schemas: schemas,
+			Responses: map[string]*openapiResponse{
+				"Error":   errorsResponse(schemas),
+				"Problem": problemsResponse(schemas),
+			},
+			SecuritySchemes: map[string]openapiSecurityScheme{"Key": {Type: "apiKey", In: "header", Name: "Key"}},
+		},
+		requestSchemas: map[string]*openapiSchema{},
+	}
+
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		// The routes of the version prefixes only hold their subrouter
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		prefix := versionPrefixOf(template)
+		for _, method := range methods {
+			key := method + " " + template
+			path := pathParameter.ReplaceAllString(template, "{$1}")
+			if document.Paths[path] == nil {
+				document.Paths[path] = map[string]*openapiOperation{}
+			}
+			// A route registered first for a version shadows the shared route with the same path
+			if document.Paths[path][strings.ToLower(method)] != nil {
+				continue
+			}
+
+			// The documentation of the routes shared by every version is keyed by their unprefixed path
+			documentation, ok := apiRoutes[key]
+			if !ok {
+				documentation = apiRoutes[method+" "+strings.TrimPrefix(template, prefix)]
+			}
+			operation := newOpenAPIOperation(template, documentation, schemas)
+			if _, ok := idempotentRoutes[method+" "+strings.TrimPrefix(template, prefix)]; ok {
+				operation.Parameters = append(operation.Parameters, openapiParameter{Name: "Idempotency-Key",
+					In: "header", Schema: &openapiSchema{Type: "string"}})
+			}
+			if prefix == v2Prefix {
+				operation.Responses["default"] = &openapiResponse{Ref: "#/components/responses/Problem"}
+			}
+			if operation.RequestBody != nil && documentation.request != nil {
+				schema := operation.RequestBody.Content["application/json"].Schema
+				document.closeObjects(schema)
+				document.requestSchemas[key] = schema
+			}
+			document.Paths[path][strings.ToLower(method)] = operation
+		}
+		return nil
+	})
+	constrainImageSchemas(schemas)
+	return document, err
+}
+
+// Reject the unknown properties of the objects of a request body like decodeStrictJSON does
+func (d *openapiDocument) closeObjects(schema *openapiSchema) {
+	if schema.Ref != "" {
+		schema = d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	switch {
+	case schema.Type == "object" && schema.AdditionalProperties == nil:
+		schema.AdditionalProperties = false
+		for _, property := range schema.Properties {
+			d.closeObjects(property)
+		}
+	case schema.Type == "array":
+		d.closeObjects(schema.Items)
+	}
+}
+
+// Add the constraints checked by the validation of the images to their request schemas
+func constrainImageSchemas(schemas map[string]*openapiSchema) {
+	minDimension, maxDimension := float64(0), float64(maxImageDimension)
+	for _, name := range []string{"CreateImageRequest", "UpdateImageRequest"} {
+		schema, ok := schemas[name]
+		if !ok {
+			continue
+		}
+		schema.Properties["name"].MinLength = 1
+		schema.Properties["name"].MaxLength = maxImageNameLength
+		schema.Required = append(schema.Required, "name")
+		if name == "CreateImageRequest" {
+			schema.Properties["extension"].Enum = declarableExtensionList()
+			for _, dimension := range []string{"height", "length"} {
+				schema.Properties[dimension].Minimum = &minDimension
+				schema.Properties[dimension].Maximum = &maxDimension
+			}
+			schema.Required = append(schema.Required, "extension")
+		}
+		sort.Strings(schema.Required)
+	}
+}
+
+// Build the operation of a route from its path template and its documentation
+func newOpenAPIOperation(template string, route apiRoute, schemas map[string]*openapiSchema) *openapiOperation {
+	operation := &openapiOperation{
+		Summary:   route.summary,
+		Responses: map[string]*openapiResponse{"default": {Ref: "#/components/responses/Error"}},
+	}
+	if route.public {
+		operation.Security = &[]map[string][]string{}
+	}
+
+	for _, match := range pathParameter.FindAllStringSubmatch(template, -1) {
+		schema := &openapiSchema{Type: "string"}
+		switch match[1] {
+		case "uuid":
+			schema.Format = "uuid"
+		case "revision", "delivery", "id":
+			schema.Type = "integer"
+		}
+		operation.Parameters = append(operation.Parameters, openapiParameter{Name: match[1], In: "path",
+			Required: true, Schema: schema})
+	}
+	for _, name := range route.query {
+		operation.Parameters = append(operation.Parameters, openapiParameter{Name: name, In: "query",
+			Schema: &openapiSchema{Type: "string"}})
+	}
+	for _, name := range route.headers {
+		operation.Parameters = append(operation.Parameters, openapiParameter{Name: name, In: "header",
+			Schema: &openapiSchema{Type: "string"}})
+	}
+
+	if route.request != nil {
+		operation.RequestBody = &openapiRequestBody{Required: true, Content: map[string]openapiMediaType{
+			"application/json": {Schema: schemaOf(reflect.TypeOf(route.request), schemas)},
+		}}
+	}
+	if route.form != nil {
+		form := &openapiSchema{Type: "object", Properties: map[string]*openapiSchema{}}
+		for name, format := range route.form {
+			form.Properties[name] = &openapiSchema{Type: "string", Format: format}
+			if format == "binary" {
+				form.Required = append(form.Required, name)
+			}
+		}
+		operation.RequestBody = &openapiRequestBody{Required: true, Content: map[string]openapiMediaType{
+			"multipart/form-data": {Schema: form},
+		}}
+	}
+
+	status := route.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := route.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	response := &openapiResponse{Description: http.StatusText(status), Content: map[string]openapiMediaType{
+		contentType: {Schema: &openapiSchema{}},
+	}}
+	if route.response != nil {
+		response.Content[contentType] = openapiMediaType{Schema: schemaOf(reflect.TypeOf(route.response), schemas)}
+	} else if contentType != "application/json" {
+		response.Content[contentType] = openapiMediaType{Schema: &openapiSchema{Type: "string", Format: "binary"}}
+	}
+	operation.Responses[fmt.Sprint(status)] = response
+	return operation
+}
+
+// Return the response shared by every operation for its errors, with an example of every ErrorResponseError
+func errorsResponse(schemas map[string]*openapiSchema) *openapiResponse {
+	examples := map[string]openapiExample{}
+	for _, e := range common.Errors {
+		examples[fmt.Sprintf("%d-%s", e.Id, e.Name)] = openapiExample{
+			Summary: fmt.Sprintf("%d %s", e.Code, http.StatusText(int(e.Code))),
+			Value:   common.ErrorResponse{Error_: e},
+		}
+	}
+	// The problem details are returned instead when the Accept header prefers them
+	return &openapiResponse{
+		Description: "Error. The HTTP status of each error is given in the summary of its example",
+		Content: map[string]openapiMediaType{
+			"application/json": {
+				Schema:   schemaOf(reflect.TypeOf(common.ErrorResponse{}), schemas),
+				Examples: examples,
+			},
+			"application/problem+json": problemsResponse(schemas).Content["application/problem+json"],
+		},
+	}
+}
+
+// Return the response shared by every operation of v2 for its errors, with the problem details of every
+// ErrorResponseError
+func problemsResponse(schemas map[string]*openapiSchema) *openapiResponse {
+	examples := map[string]openapiExample{}
+	for _, e := range common.Errors {
+		examples[fmt.Sprintf("%d-%s", e.Id, e.Name)] = openapiExample{
+			Summary: fmt.Sprintf("%d %s", e.Code, http.StatusText(int(e.Code))),
+			Value:   common.NewProblem(e),
+		}
+	}
+	return &openapiResponse{
+		Description: "Error described by the problem details of RFC 7807",
+		Content: map[string]openapiMediaType{"application/problem+json": {
+			Schema:   schemaOf(reflect.TypeOf(common.Problem{}), schemas),
+			Examples: examples,
+		}},
+	}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Return the schema of the JSON encoding of the type. The named structs are added to the schemas and referenced
+func schemaOf(t reflect.Type, schemas map[string]*openapiSchema) *openapiSchema {
+	switch t {
+	case timeType:
+		return &openapiSchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &openapiSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := *schemaOf(t.Elem(), schemas)
+		// Siblings of a $ref are ignored by OpenAPI 3.0
+		schema.Nullable = schema.Ref == ""
+		return &schema
+	case reflect.Struct:
+		if t.Name() == "" {
+			return objectSchemaOf(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// The schema is registered before its fields are read in case the type is recursive
+			schema := &openapiSchema{}
+			schemas[t.Name()] = schema
+			*schema = *objectSchemaOf(t, schemas)
+		}
+		return &openapiSchema{Ref: "#/components/schemas/" + t.Name()}
+	case reflect.Slice:
+		return &openapiSchema{Type: "array", Items: schemaOf(t.Elem(), schemas), Nullable: true}
+	case reflect.Array:
+		return &openapiSchema{Type: "array", Items: schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return &openapiSchema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), schemas), Nullable: true}
+	case reflect.String:
+		return &openapiSchema{Type: "string"}
+	case reflect.Bool:
+		return &openapiSchema{Type: "boolean"}
+	case reflect.Int32, reflect.Uint16, reflect.Int16, reflect.Int8, reflect.Uint8:
+		return &openapiSchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &openapiSchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openapiSchema{Type: "number"}
+	}
+	return &openapiSchema{}
+}
+
+// Return the object schema of the fields of the struct. The fields without omitempty are always encoded so they are
+// required
+func objectSchemaOf(t reflect.Type, schemas map[string]*openapiSchema) *openapiSchema {
+	schema := &openapiSchema{Type: "object", Properties: map[string]*openapiSchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		// The fields of the embedded structs are encoded as fields of the struct
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			embedded := objectSchemaOf(field.Type, schemas)
+			for name, property := range embedded.Properties {
+				schema.Properties[name] = property
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		options := strings.Split(tag, ",")
+		name := options[0]
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemaOf(field.Type, schemas)
+		if !strings.Contains(tag, ",omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// Return every part of the value decoded from JSON that doesn't respect the schema, named by its path in the body
+func (d *openapiDocument) validate(schema *openapiSchema, value interface{}, path string) []common.InvalidParam {
+	if schema.Ref != "" {
+		schema = d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	invalid := func(reason string) []common.InvalidParam {
+		return []common.InvalidParam{{Name: path, Reason: reason}}
+	}
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return invalid("must not be null")
+	}
+
+	var params []common.InvalidParam
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("must be an object")
+		}
+		for _, required := range schema.Required {
+			if _, ok := object[required]; !ok {
+				params = append(params, common.InvalidParam{Name: joinPath(path, required), Reason: "is required"})
+			}
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := schema.Properties[key]
+			if !ok {
+				switch additional := schema.AdditionalProperties.(type) {
+				case *openapiSchema:
+					property = additional
+				case bool:
+					if !additional {
+						params = append(params, common.InvalidParam{Name: joinPath(path, key),
+							Reason: "is not a known property"})
+					}
+				}
+			}
+			if property == nil {
+				continue
+			}
+			params = append(params, d.validate(property, object[key], joinPath(path, key))...)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return invalid("must be an array")
+		}
+		for i, item := range items {
+			params = append(params, d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return invalid("must be a string")
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return invalid("must be an RFC 3339 date-time")
+			}
+		}
+		if len(schema.Enum) > 0 && !containsString(schema.Enum, s) {
+			return invalid("must be one of " + strings.Join(schema.Enum, ", "))
+		}
+		if length := utf8.RuneCountInString(s); length == 0 && schema.MinLength > 0 {
+			return invalid("must not be empty")
+		} else if length < schema.MinLength {
+			return invalid(fmt.Sprintf("must be at least %d characters long", schema.MinLength))
+		} else if schema.MaxLength > 0 && length > schema.MaxLength {
+			return invalid(fmt.Sprintf("must be at most %d characters long", schema.MaxLength))
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return invalid("must be an integer")
+		}
+		if schema.Format == "int32" && (n > math.MaxInt32 || n < math.MinInt32) {
+			return invalid("must be a 32-bit integer")
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			return invalid(fmt.Sprintf("must be at least %v", *schema.Minimum))
+		} else if schema.Maximum != nil && n > *schema.Maximum {
+			return invalid(fmt.Sprintf("must be at most %v", *schema.Maximum))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return invalid("must be a number")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return invalid("must be a boolean")
+		}
+	}
+	return params
+}
+
+// Return the path of the property of the object at the path
+func joinPath(path, property string) string {
+	if path == "" {
+		return property
+	}
+	return path + "." + property
+}
+
+// Return true if the value is one of the values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
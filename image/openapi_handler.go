@@ -0,0 +1,79 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Size above which the JSON request bodies are rejected without being validated
+const maxValidatedBodySize = 1 << 20
+
+// Handle the request to get the OpenAPI document of the API
+func (h *Handler) HandleGetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(h.openapi)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Validate the JSON body of the requests against the schema of their route in the OpenAPI document before they reach
+// the handlers. The body is restored so that the handlers can decode it
+func (h *Handler) validationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		schema, ok := h.openapi.requestSchemas[r.Method+" "+template]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxValidatedBodySize+1))
+		if err != nil || len(body) > maxValidatedBodySize {
+			respondWithInvalidBody(w, "The request body is too large")
+			return
+		}
+		var value interface{}
+		err = json.Unmarshal(body, &value)
+		if err != nil {
+			respondWithInvalidBody(w, "The request body isn't valid JSON")
+			return
+		}
+		if params := h.openapi.validate(schema, value, ""); len(params) > 0 {
+			respondWithInvalidParams(w, params)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Respond with an InvalidRequestBodyError whose detail describes the problem
+func respondWithInvalidBody(w http.ResponseWriter, detail string) {
+	errResponse := common.InvalidRequestBodyError
+	errResponse.Detail = detail
+	w.Header().Set("Content-Type", "application/json")
+	common.RespondWithError(w, &errResponse)
+}
+
+// Respond with an InvalidRequestBodyError listing every invalid part of the body
+func respondWithInvalidParams(w http.ResponseWriter, params []common.InvalidParam) {
+	w.Header().Set("Content-Type", "application/json")
+	common.RespondWithError(w, invalidParamsError(params))
+}
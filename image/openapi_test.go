@@ -0,0 +1,155 @@
+package image
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+func TestOpenAPIValidate(t *testing.T) {
+	handler := &Handler{}
+	if _, err := newRouter(handler); err != nil {
+		t.Fatalf("building the router: %v", err)
+	}
+	document := handler.openapi
+
+	tests := []struct {
+		name  string
+		route string
+		body  string
+		want  []common.InvalidParam
+	}{
+		{
+			name:  "valid image",
+			route: "POST /image",
+			body:  `{"name": "cat", "extension": "png", "height": 0, "length": 65535}`,
+		},
+		{
+			name:  "upper case extension",
+			route: "POST /image",
+			body:  `{"name": "cat", "extension": "PNG"}`,
+		},
+		{
+			name:  "missing properties",
+			route: "POST /image",
+			body:  `{}`,
+			want: []common.InvalidParam{{Name: "extension", Reason: "is required"},
+				{Name: "name", Reason: "is required"}},
+		},
+		{
+			name:  "unknown property",
+			route: "POST /image",
+			body:  `{"name": "cat", "extension": "png", "colour": "red"}`,
+			want:  []common.InvalidParam{{Name: "colour", Reason: "is not a known property"}},
+		},
+		{
+			name:  "body that isn't an object",
+			route: "POST /image",
+			body:  `[]`,
+			want:  []common.InvalidParam{{Name: "", Reason: "must be an object"}},
+		},
+		{
+			name:  "null property",
+			route: "POST /image",
+			body:  `{"name": null, "extension": "png"}`,
+			want:  []common.InvalidParam{{Name: "name", Reason: "must not be null"}},
+		},
+		{
+			name:  "empty name",
+			route: "POST /image",
+			body:  `{"name": "", "extension": "png"}`,
+			want:  []common.InvalidParam{{Name: "name", Reason: "must not be empty"}},
+		},
+		{
+			name:  "name too long",
+			route: "PATCH /image/{uuid}",
+			body:  `{"name": "` + strings.Repeat("é", 65) + `"}`,
+			want:  []common.InvalidParam{{Name: "name", Reason: "must be at most 64 characters long"}},
+		},
+		{
+			name:  "extension outside the enum",
+			route: "POST /image",
+			body:  `{"name": "cat", "extension": "Png"}`,
+			want: []common.InvalidParam{{Name: "extension", Reason: "must be one of " +
+				strings.Join(declarableExtensionList(), ", ")}},
+		},
+		{
+			name:  "dimensions out of range",
+			route: "POST /image",
+			body:  `{"name": "cat", "extension": "png", "height": -1, "length": 65536}`,
+			want: []common.InvalidParam{{Name: "height", Reason: "must be at least 0"},
+				{Name: "length", Reason: "must be at most 65535"}},
+		},
+		{
+			name:  "wrong types",
+			route: "POST /image",
+			body:  `{"name": 1, "extension": "png", "height": 1.5, "length": "tall"}`,
+			want: []common.InvalidParam{{Name: "height", Reason: "must be an integer"},
+				{Name: "length", Reason: "must be an integer"}, {Name: "name", Reason: "must be a string"}},
+		},
+		{
+			name:  "nested unknown property",
+			route: "POST /images/batch/create",
+			body:  `{"images": [{"name": "cat", "extension": "png"}, {"name": "dog", "extension": "gif", "x": 1}]}`,
+			want:  []common.InvalidParam{{Name: "images[1].x", Reason: "is not a known property"}},
+		},
+		{
+			name:  "array expected",
+			route: "POST /images/batch/delete",
+			body:  `{"uuids": "4bdc5ee4-8d31-4e11-9d48-cc4e0bcf5a4b"}`,
+			want:  []common.InvalidParam{{Name: "uuids", Reason: "must be an array"}},
+		},
+		{
+			name:  "date-time",
+			route: "POST /admin/revocation",
+			body:  `{"username": "bob", "issuedBefore": "yesterday"}`,
+			want:  []common.InvalidParam{{Name: "issuedBefore", Reason: "must be an RFC 3339 date-time"}},
+		},
+		{
+			name:  "map of any values",
+			route: "POST /graphql",
+			body:  `{"query": "{ __typename }", "variables": {"first": 10, "after": null}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, ok := document.requestSchemas[tt.route]
+			if !ok {
+				t.Fatalf("no request schema for %s", tt.route)
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.body), &value); err != nil {
+				t.Fatalf("decoding the body: %v", err)
+			}
+			if got := document.validate(schema, value, ""); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAPIRequestSchemaConstraints(t *testing.T) {
+	handler := &Handler{}
+	if _, err := newRouter(handler); err != nil {
+		t.Fatalf("building the router: %v", err)
+	}
+	encoded, err := json.Marshal(handler.openapi.Components.Schemas["CreateImageRequest"])
+	if err != nil {
+		t.Fatalf("encoding the schema: %v", err)
+	}
+
+	for _, want := range []string{
+		`"required":["extension","name"]`,
+		`"additionalProperties":false`,
+		`"name":{"type":"string","minLength":1,"maxLength":64}`,
+		`"height":{"type":"integer","format":"int32","minimum":0,"maximum":65535}`,
+		`"enum":["bmp","gif","jpeg","jpg","png","webp","BMP","GIF","JPEG","JPG","PNG","WEBP"]`,
+	} {
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("got %s, want it to contain %s", encoded, want)
+		}
+	}
+}
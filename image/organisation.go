@@ -0,0 +1,226 @@
+package image
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+type Organisation struct {
+	UUID      uuid.UUID
+	Name      string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+type Member struct {
+	Username string
+	Role     Role
+}
+
+// Return true if the role is one of the supported roles
+func (r Role) isValid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Return true if the role grants at least the permissions of the required role
+func (r Role) includes(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// Convert a CreateOrganisationRequest into an Organisation object
+func (o CreateOrganisationRequest) toOrganisation(createdBy string) Organisation {
+	return Organisation{
+		UUID:      uuid.New(),
+		Name:      o.Name,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Convert an Organisation into an OrganisationResponse object
+func (o Organisation) toOrganisationResponse(role Role, members []Member) OrganisationResponse {
+	response := OrganisationResponse{
+		Uuid:      o.UUID.String(),
+		Name:      o.Name,
+		CreatedBy: o.CreatedBy,
+		CreatedAt: o.CreatedAt,
+		Role:      string(role),
+	}
+	for _, m := range members {
+		response.Members = append(response.Members, MemberResponse{Username: m.Username, Role: string(m.Role)})
+	}
+	return response
+}
+
+// Create the organisation and make its creator the first admin
+func CreateOrganisation(db *sql.DB, organisation Organisation) error {
+	uuidToCreate, err := organisation.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO organisations (UUID, name, createdBy, createdAt) VALUES (?, ?, ?, ?)",
+		uuidToCreate, organisation.Name, organisation.CreatedBy, organisation.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO organisation_members (organisation, username, role) VALUES (?, ?, ?)",
+		uuidToCreate, organisation.CreatedBy, RoleAdmin)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Return the organisation record associated to the uuid
+func GetOrganisation(db *sql.DB, id uuid.UUID) (*Organisation, error) {
+	uuidToGet, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	organisation := &Organisation{}
+	var uuidToParse []byte
+	err = db.QueryRow("SELECT UUID, name, createdBy, createdAt FROM organisations WHERE UUID = ?", uuidToGet).
+		Scan(&uuidToParse, &organisation.Name, &organisation.CreatedBy, &organisation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = organisation.UUID.UnmarshalBinary(uuidToParse)
+	if err != nil {
+		return nil, err
+	}
+	return organisation, nil
+}
+
+// Return the organisations the user is a member of along with the user's role in each of them
+func GetOrganisations(db *sql.DB, username string) ([]Organisation, []Role, error) {
+	rows, err := db.Query("SELECT o.UUID, o.name, o.createdBy, o.createdAt, m.role FROM organisations o "+
+		"JOIN organisation_members m ON m.organisation = o.UUID WHERE m.username = ? ORDER BY o.name", username)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	organisations := make([]Organisation, 0)
+	roles := make([]Role, 0)
+	for rows.Next() {
+		var organisation Organisation
+		var role Role
+		var uuidToParse []byte
+		err = rows.Scan(&uuidToParse, &organisation.Name, &organisation.CreatedBy, &organisation.CreatedAt, &role)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = organisation.UUID.UnmarshalBinary(uuidToParse)
+		if err != nil {
+			return nil, nil, err
+		}
+		organisations = append(organisations, organisation)
+		roles = append(roles, role)
+	}
+	return organisations, roles, rows.Err()
+}
+
+// Return the role of the user in the organisation or sql.ErrNoRows if the user isn't a member
+func GetMemberRole(db *sql.DB, organisation uuid.UUID, username string) (Role, error) {
+	uuidToGet, err := organisation.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+
+	var role Role
+	err = db.QueryRow("SELECT role FROM organisation_members WHERE organisation = ? AND username = ?",
+		uuidToGet, username).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// Return the members of the organisation
+func GetMembers(db *sql.DB, organisation uuid.UUID) ([]Member, error) {
+	uuidToGet, err := organisation.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT username, role FROM organisation_members WHERE organisation = ? "+
+		"ORDER BY username", uuidToGet)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]Member, 0)
+	for rows.Next() {
+		var member Member
+		err = rows.Scan(&member.Username, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// Add the user to the organisation or update its role if it is already a member
+func SetMember(db *sql.DB, organisation uuid.UUID, member Member) error {
+	uuidToUpdate, err := organisation.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO organisation_members (organisation, username, role) VALUES (?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE role = VALUES(role)", uuidToUpdate, member.Username, member.Role)
+	return err
+}
+
+// Remove the user from the organisation
+func DeleteMember(db *sql.DB, organisation uuid.UUID, username string) error {
+	uuidToUpdate, err := organisation.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM organisation_members WHERE organisation = ? AND username = ?",
+		uuidToUpdate, username)
+	return err
+}
+
+// Return the number of admins of the organisation
+func CountAdmins(db *sql.DB, organisation uuid.UUID) (int, error) {
+	uuidToCount, err := organisation.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM organisation_members WHERE organisation = ? AND role = ?",
+		uuidToCount, RoleAdmin).Scan(&count)
+	return count, err
+}
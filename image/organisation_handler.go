@@ -0,0 +1,249 @@
+package image
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Handle the API request to create an organisation. The authenticated user becomes its first admin
+func (h *Handler) HandlePostOrganisation(w http.ResponseWriter, r *http.Request) {
+	var request CreateOrganisationRequest
+	w.Header().Set("Content-Type", "application/json")
+	errResponse := decodeStrictJSON(r.Body, &request)
+	if errResponse == nil && (request.Name == "" || len(request.Name) > 64) {
+		errResponse = &common.InvalidRequestBodyError
+	}
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	username, errResponse := h.authenticate(r, ScopeOrgsWrite)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	organisation := request.toOrganisation(username)
+	err := CreateOrganisation(h.db, organisation)
+	if err != nil {
+		common.RespondWithError(w, &common.DatabaseInsertionError)
+		return
+	}
+
+	response := organisation.toOrganisationResponse(RoleAdmin, []Member{{Username: username, Role: RoleAdmin}})
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to list the organisations the authenticated user is a member of
+func (h *Handler) HandleGetOrganisations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, errResponse := h.authenticate(r, ScopeOrgsRead)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	organisations, roles, err := GetOrganisations(h.db, username)
+	if err != nil {
+		common.RespondWithError(w, &common.OrganisationDBError)
+		return
+	}
+
+	response := make(OrganisationsResponse, 0)
+	for i, o := range organisations {
+		response = append(response, o.toOrganisationResponse(roles[i], nil))
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to get an organisation and its members
+func (h *Handler) HandleGetOrganisation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	uuidToGet, err := uuid.Parse(vars["uuid"])
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidUUIDError)
+		return
+	}
+
+	username, errResponse := h.authenticate(r, ScopeOrgsRead)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	role, err := GetMemberRole(h.db, uuidToGet, username)
+	if err == sql.ErrNoRows {
+		common.RespondWithError(w, &common.OrganisationNotFoundError)
+		return
+	} else if err != nil {
+		common.RespondWithError(w, &common.OrganisationDBError)
+		return
+	}
+
+	organisation, err := GetOrganisation(h.db, uuidToGet)
+	if err != nil {
+		common.RespondWithError(w, &common.OrganisationNotFoundError)
+		return
+	}
+	members, err := GetMembers(h.db, uuidToGet)
+	if err != nil {
+		common.RespondWithError(w, &common.OrganisationDBError)
+		return
+	}
+
+	response := organisation.toOrganisationResponse(role, members)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to add a member to an organisation or change its role
+func (h *Handler) HandlePutMember(w http.ResponseWriter, r *http.Request) {
+	var request SetMemberRequest
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	organisation, err := uuid.Parse(vars["uuid"])
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidUUIDError)
+		return
+	}
+	member := Member{Username: vars["username"]}
+	if member.Username == "" || len(member.Username) > 32 {
+		common.RespondWithError(w, &common.InvalidRequestBodyError)
+		return
+	}
+
+	errResponse := decodeStrictJSON(r.Body, &request)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	member.Role = Role(request.Role)
+	if !member.Role.isValid() {
+		common.RespondWithError(w, &common.InvalidRoleError)
+		return
+	}
+
+	username, errResponse := h.authenticate(r, ScopeOrgsWrite)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	errResponse = h.authorizeOrganisation(username, organisation, RoleAdmin)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	errResponse = h.ensureAdminRemains(organisation, member.Username, member.Role)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	err = SetMember(h.db, organisation, member)
+	if err != nil {
+		common.RespondWithError(w, &common.DatabaseInsertionError)
+		return
+	}
+
+	response := MemberResponse{Username: member.Username, Role: string(member.Role)}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to remove a member from an organisation. Members can always remove themselves
+func (h *Handler) HandleDeleteMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	organisation, err := uuid.Parse(vars["uuid"])
+	if err != nil {
+		common.RespondWithError(w, &common.InvalidUUIDError)
+		return
+	}
+	memberUsername := vars["username"]
+
+	username, errResponse := h.authenticate(r, ScopeOrgsWrite)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if username == memberUsername {
+		errResponse = h.authorizeOrganisation(username, organisation, RoleViewer)
+	} else {
+		errResponse = h.authorizeOrganisation(username, organisation, RoleAdmin)
+	}
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	role, err := GetMemberRole(h.db, organisation, memberUsername)
+	if err == sql.ErrNoRows {
+		common.RespondWithError(w, &common.MemberNotFoundError)
+		return
+	} else if err != nil {
+		common.RespondWithError(w, &common.OrganisationDBError)
+		return
+	}
+
+	errResponse = h.ensureAdminRemains(organisation, memberUsername, "")
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	err = DeleteMember(h.db, organisation, memberUsername)
+	if err != nil {
+		common.RespondWithError(w, &common.DBDeletionError)
+		return
+	}
+
+	response := MemberResponse{Username: memberUsername, Role: string(role)}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Ensure that giving the new role to the member (an empty role removes it) leaves the organisation with an admin
+func (h *Handler) ensureAdminRemains(organisation uuid.UUID, username string, newRole Role) *common.ErrorResponseError {
+	if newRole == RoleAdmin {
+		return nil
+	}
+
+	role, err := GetMemberRole(h.db, organisation, username)
+	if err == sql.ErrNoRows || (err == nil && role != RoleAdmin) {
+		return nil
+	}
+	if err != nil {
+		return &common.OrganisationDBError
+	}
+
+	admins, err := CountAdmins(h.db, organisation)
+	if err != nil {
+		return &common.OrganisationDBError
+	}
+	if admins <= 1 {
+		return &common.LastOrganisationAdminError
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package image
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("error the cursor is invalid")
+
+// Return the cursor pointing after the image
+func imageCursorOf(image Image) ImageCursor {
+	return ImageCursor{CreatedAt: image.CreatedAt, UUID: image.UUID}
+}
+
+// Encode the cursor into the opaque string returned to the clients
+func (c ImageCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" +
+		c.UUID.String()))
+}
+
+// Decode a cursor previously returned to a client
+func decodeImageCursor(encoded string) (*ImageCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &ImageCursor{CreatedAt: createdAt, UUID: id}, nil
+}
+
+// Return the page size requested by the limit query parameter, or the default one if it is absent
+func pageSizeOf(query url.Values) (int, *common.ErrorResponseError) {
+	if query.Get("limit") == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		return 0, &common.InvalidPageSizeError
+	}
+	return limit, nil
+}
@@ -0,0 +1,60 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Request ids sent by the clients that are kept rather than replaced by a generated one
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Give every request an id sent back in the X-Request-ID header so that a response can be matched with the logs. The
+// id sent by the client or by a proxy is kept if it is valid
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(common.RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(common.RequestIDHeader, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Make the handlers respond with problem details rather than the JSON errors on the v2 routes, and on the other routes
+// when the Accept header prefers them, so that the handlers are shared by every version of the API
+func problemMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if versionPrefixOf(r.URL.Path) == v2Prefix || common.AcceptsProblem(r.Header.Get("Accept")) {
+			w = &common.ProblemResponseWriter{ResponseWriter: w, Instance: r.URL.Path}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handle the request to describe the problem type of an error
+func HandleGetProblemType(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		common.RespondWithError(w, &common.ProblemTypeNotFoundError)
+		return
+	}
+	errResponse := common.ErrorById(int32(id))
+	if errResponse == nil {
+		common.RespondWithError(w, &common.ProblemTypeNotFoundError)
+		return
+	}
+
+	response := common.NewProblemType(errResponse)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
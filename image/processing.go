@@ -0,0 +1,38 @@
+package image
+
+import (
+	stdimage "image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Validate the uploaded file and complete the image with the dimensions read from it. Return the failure reason or
+// an empty string if the file is valid
+func processImage(file io.ReadSeeker, image *Image) string {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return failureNotAnImage
+	}
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return failureNotAnImage
+	}
+	if !strings.HasPrefix(http.DetectContentType(header[:n]), "image/") {
+		return failureNotAnImage
+	}
+
+	// Only the formats with a registered decoder can be measured, the others keep the declared dimensions
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return failureNotAnImage
+	}
+	config, _, err := stdimage.DecodeConfig(file)
+	if err == nil {
+		image.Height = int32(config.Height)
+		image.Length = int32(config.Width)
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package image
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+)
+
+var ErrQuotaExceeded = errors.New("error the owner reached its quota")
+
+type Quota struct {
+	// Maximum number of images an owner can have. Unlimited when 0
+	MaxObjects int64
+	// Maximum number of bytes an owner can store. Unlimited when 0
+	MaxBytes int64
+}
+
+type Usage struct {
+	Objects int64
+	Bytes   int64
+}
+
+// Read the quota from the QUOTA_MAX_OBJECTS and QUOTA_MAX_BYTES environment variables
+func QuotaFromEnv() Quota {
+	return Quota{
+		MaxObjects: parseQuotaVariable("QUOTA_MAX_OBJECTS"),
+		MaxBytes:   parseQuotaVariable("QUOTA_MAX_BYTES"),
+	}
+}
+
+// Parse an optional quota environment variable. A missing variable means unlimited
+func parseQuotaVariable(name string) int64 {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return 0
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		panic("fatal: environment variable " + name + " must be a positive integer")
+	}
+	return limit
+}
+
+// Return true if the owner can replace a stored file of oldSize bytes by one of newSize bytes
+func (q Quota) allowsBytes(usage Usage, oldSize, newSize int64) bool {
+	return q.MaxBytes == 0 || usage.Bytes-oldSize+newSize <= q.MaxBytes
+}
+
+// Convert the usage and the quota into a UsageResponse object
+func (u Usage) toUsageResponse(quota Quota) UsageResponse {
+	return UsageResponse{
+		Objects:    u.Objects,
+		Bytes:      u.Bytes,
+		MaxObjects: quota.MaxObjects,
+		MaxBytes:   quota.MaxBytes,
+	}
+}
+
+// Return the number of images and the number of bytes stored by the owner. The bytes are those of every kept revision
+// of the images rather than only of their current file
+func GetUsage(db *sql.DB, owner string) (Usage, error) {
+	var usage Usage
+	err := db.QueryRow("SELECT (SELECT COUNT(*) FROM images WHERE owner = ? AND deletedAt IS NULL), "+
+		"(SELECT COALESCE(SUM(r.size), 0) FROM image_revisions r JOIN images i ON i.UUID = r.image "+
+		"WHERE i.owner = ? AND i.deletedAt IS NULL)", owner, owner).
+		Scan(&usage.Objects, &usage.Bytes)
+	return usage, err
+}
+
+// Lock the usage row of the owner until the end of the transaction and return its number of images. The row is
+// created on first use
+func lockUsage(tx *sql.Tx, owner string) (int64, error) {
+	_, err := tx.Exec("INSERT IGNORE INTO usage_locks (owner) VALUES (?)", owner)
+	if err != nil {
+		return 0, err
+	}
+	var locked string
+	err = tx.QueryRow("SELECT owner FROM usage_locks WHERE owner = ? FOR UPDATE", owner).Scan(&locked)
+	if err != nil {
+		return 0, err
+	}
+
+	var objects int64
+	err = tx.QueryRow("SELECT COUNT(*) FROM images WHERE owner = ? AND deletedAt IS NULL", owner).Scan(&objects)
+	return objects, err
+}
@@ -0,0 +1,231 @@
+package image
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+const (
+	defaultRateLimitRoute  = "default"
+	memoryRateLimitMaxKeys = 100000
+)
+
+type RateLimit struct {
+	// Size of the bucket, which is the maximum burst of requests
+	Requests int
+	// Time needed to refill an empty bucket
+	Period time.Duration
+}
+
+// Limits applied to the routes by name. Each named route has its own bucket while the routes without a name share the
+// bucket of the default limit, and routes mapped to the zero RateLimit aren't limited. Uploading and generating signed
+// URLs are the most expensive operations
+var routeRateLimits = map[string]RateLimit{
+	defaultRateLimitRoute: {Requests: 300, Period: time.Minute},
+	"upload":              {Requests: 30, Period: time.Minute},
+	"signedURL":           {Requests: 120, Period: time.Minute},
+	"healthz":             {},
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Time until the bucket is full again
+	Reset time.Duration
+	// Time until the next request is allowed when it wasn't
+	RetryAfter time.Duration
+}
+
+// Store holding the token buckets. The in-memory store limits each replica independently while an implementation
+// backed by a shared store (such as Redis) would limit the whole service
+type RateLimitStore interface {
+	// Take cost tokens from the bucket identified by the key. A cost larger than the bucket takes the whole bucket
+	Take(key string, limit RateLimit, cost int) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// Refill rate in tokens per second and capacity of the limit the bucket was last taken from
+	rate     float64
+	capacity float64
+}
+
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// Return an in-memory RateLimitStore
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+// Take cost tokens from the bucket identified by the key after refilling it for the elapsed time
+func (s *memoryRateLimitStore) Take(key string, limit RateLimit, cost int) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Period.Seconds()
+	tokens := math.Min(float64(cost), capacity)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= memoryRateLimitMaxKeys {
+			s.evictFull(now)
+		}
+		bucket = &tokenBucket{tokens: capacity, updatedAt: now}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*rate)
+	bucket.updatedAt = now
+	bucket.rate = rate
+	bucket.capacity = capacity
+
+	result := RateLimitResult{Limit: limit.Requests}
+	if bucket.tokens >= tokens {
+		bucket.tokens -= tokens
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((tokens - bucket.tokens) / rate)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = secondsToDuration((capacity - bucket.tokens) / rate)
+	return result, nil
+}
+
+// Remove the buckets that are full since they are equivalent to a missing bucket. Each bucket is refilled with its
+// own limit. The caller must hold the lock
+func (s *memoryRateLimitStore) evictFull(now time.Time) {
+	for key, bucket := range s.buckets {
+		if bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*bucket.rate >= bucket.capacity {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Convert a number of seconds into a duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Bucket the request took its token from, kept on the context so that the handler can charge the extra items of
+// a batch to it
+type rateLimitBucket struct {
+	key   string
+	limit RateLimit
+}
+
+type rateLimitBucketKey struct{}
+
+// Middleware limiting the requests per route and per authenticated user, falling back on the client IP
+func (h *Handler) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var name, key string
+		if route := mux.CurrentRoute(r); route != nil {
+			name, key = route.GetName(), routeKey(r, route)
+		}
+		bucket := rateLimitBucketOf(name, key)
+		if bucket.limit.Requests == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bucket.key += ":" + h.rateLimitIdentity(r)
+		r = r.WithContext(context.WithValue(r.Context(), rateLimitBucketKey{}, bucket))
+		if h.takeRateLimit(w, r, 1) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Return the bucket of the route with the name and the key. Each named route has its own bucket while the other routes
+// share the default one
+func rateLimitBucketOf(name, key string) rateLimitBucket {
+	if limit, ok := routeRateLimits[name]; ok && name != "" {
+		return rateLimitBucket{key: name + ":" + key, limit: limit}
+	}
+	return rateLimitBucket{key: defaultRateLimitRoute, limit: routeRateLimits[defaultRateLimitRoute]}
+}
+
+// Charge the items of a batch request beyond the first one, which was charged by the middleware. false is returned
+// after responding with an error if the request exceeds the limit
+func (h *Handler) chargeRateLimitItems(w http.ResponseWriter, r *http.Request, items int) bool {
+	if items <= 1 {
+		return true
+	}
+	return h.takeRateLimit(w, r, items-1)
+}
+
+// Take cost tokens from the bucket of the request and set the rate limit headers. false is returned after
+// responding with an error if the bucket doesn't hold enough tokens
+func (h *Handler) takeRateLimit(w http.ResponseWriter, r *http.Request, cost int) bool {
+	bucket, ok := r.Context().Value(rateLimitBucketKey{}).(rateLimitBucket)
+	if !ok {
+		return true
+	}
+	result, err := h.rateLimits.Take(bucket.key, bucket.limit, cost)
+	if err != nil {
+		// The store being unavailable shouldn't make the whole service unavailable
+		log.Println(err.Error())
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+	if !result.Allowed {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+		common.RespondWithError(w, &common.TooManyRequestsError)
+		return false
+	}
+	return true
+}
+
+// Return the method and the unprefixed path template of the route so that every version of the API shares a bucket
+func routeKey(r *http.Request, route *mux.Route) string {
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return r.Method
+	}
+	return r.Method + " " + strings.TrimPrefix(template, versionPrefixOf(template))
+}
+
+// Return the identity the request is limited by: the authenticated username or the client IP. The authentication is
+// shared with the handler
+func (h *Handler) rateLimitIdentity(r *http.Request) string {
+	if username, _, errResponse := h.identify(r); errResponse == nil {
+		return "user:" + username
+	}
+	return "ip:" + clientIP(r)
+}
+
+// Return the IP of the client. The X-Forwarded-For header is only trusted when RATE_LIMIT_TRUST_FORWARDED is set
+// since clients could otherwise spoof it to get a fresh bucket
+func clientIP(r *http.Request) string {
+	if os.Getenv("RATE_LIMIT_TRUST_FORWARDED") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,48 @@
+package image
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreEvictFull(t *testing.T) {
+	slow := RateLimit{Requests: 30, Period: time.Minute}
+	fast := RateLimit{Requests: 300, Period: time.Minute}
+	tests := []struct {
+		name      string
+		limit     RateLimit
+		cost      int
+		elapsed   time.Duration
+		wantEvict bool
+	}{
+		{name: "slow bucket refilled", limit: slow, cost: 30, elapsed: time.Minute, wantEvict: true},
+		// The fast limit would refill the bucket within 6 seconds
+		{name: "slow bucket still refilling", limit: slow, cost: 30, elapsed: 10 * time.Second},
+		{name: "fast bucket refilled", limit: fast, cost: 300, elapsed: time.Minute, wantEvict: true},
+		// The slow limit would never fill a bucket of 300 tokens
+		{name: "fast bucket refilled above the slow capacity", limit: fast, cost: 100, elapsed: 20 * time.Second,
+			wantEvict: true},
+		{name: "fast bucket still refilling", limit: fast, cost: 300, elapsed: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryRateLimitStore().(*memoryRateLimitStore)
+			if _, err := store.Take("bucket", tt.limit, tt.cost); err != nil {
+				t.Fatalf("taking the tokens: %v", err)
+			}
+			// A bucket of the other limit shares the store
+			other := slow
+			if tt.limit == slow {
+				other = fast
+			}
+			if _, err := store.Take("other", other, 1); err != nil {
+				t.Fatalf("taking the tokens: %v", err)
+			}
+
+			store.evictFull(store.buckets["bucket"].updatedAt.Add(tt.elapsed))
+			if _, kept := store.buckets["bucket"]; kept == tt.wantEvict {
+				t.Errorf("got the bucket kept: %v, want it evicted: %v", kept, tt.wantEvict)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package image
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultCreatedTTL   = 24 * time.Hour
+	defaultUploadTTL    = time.Hour
+	defaultMinObjectAge = time.Hour
+)
+
+type ReconcileOptions struct {
+	// Only report the mismatches without repairing them
+	DryRun bool
+	// Age after which an image still in the CREATED status is expired
+	CreatedTTL time.Duration
+	// Age after which an image still in the UPLOADING or PROCESSING status is marked as FAILED
+	UploadTTL time.Duration
+	// Objects updated more recently than this are never considered orphans
+	MinObjectAge time.Duration
+}
+
+type ReconcileReport struct {
+	Bucket string `json:"bucket"`
+	DryRun bool   `json:"dryRun"`
+	// uuids of the images stuck in the CREATED status for longer than the TTL
+	StaleCreated []string `json:"staleCreated"`
+	// uuids of the images stuck in the UPLOADING or PROCESSING status for longer than the TTL
+	StaleUploads []string `json:"staleUploads"`
+	// paths of the objects that aren't referenced by any image
+	OrphanObjects []string `json:"orphanObjects"`
+	// uuids of the uploaded images whose object doesn't exist
+	MissingObjects []string `json:"missingObjects"`
+	// errors that occurred while repairing the mismatches
+	Errors []string `json:"errors,omitempty"`
+}
+
+type reconcileRecord struct {
+	UUID       uuid.UUID
+	BucketPath string
+	Status     Status
+	CreatedAt  time.Time
+	// Time of the last status change, which is the creation time for the images that never changed status
+	StatusChangedAt time.Time
+	Deleted         bool
+}
+
+// Compare the objects of the bucket with the image records, report the mismatches and repair them unless in dry-run
+// mode. Stale CREATED images are deleted, orphan objects are deleted, and stale uploads along with images whose
+// object is missing are marked as FAILED
+func Reconcile(db *sql.DB, bucket string, options ReconcileOptions) (ReconcileReport, error) {
+	report := ReconcileReport{
+		Bucket:         bucket,
+		DryRun:         options.DryRun,
+		StaleCreated:   make([]string, 0),
+		StaleUploads:   make([]string, 0),
+		OrphanObjects:  make([]string, 0),
+		MissingObjects: make([]string, 0),
+	}
+
+	// The objects are listed before the records. Since a record is always created before its object, every listed
+	// object that has a record will find it
+	objects, err := listObjects(bucket)
+	if err != nil {
+		return report, err
+	}
+	records, err := GetReconcileRecords(db, bucket)
+	if err != nil {
+		return report, err
+	}
+	revisionPaths, err := GetRevisionPaths(db, bucket)
+	if err != nil {
+		return report, err
+	}
+
+	storedPaths := make(map[string]bool)
+	for _, o := range objects {
+		storedPaths[o.Name] = true
+	}
+	knownPaths := make(map[string]bool)
+	for _, r := range records {
+		knownPaths[r.BucketPath] = true
+	}
+	for _, path := range revisionPaths {
+		knownPaths[path] = true
+	}
+
+	now := time.Now().UTC()
+	for _, r := range records {
+		if r.Deleted {
+			// The StorageReaper is in charge of the soft deleted images
+			continue
+		}
+		if r.Status == StatusCreated && now.Sub(r.CreatedAt) > options.CreatedTTL {
+			report.StaleCreated = append(report.StaleCreated, r.UUID.String())
+			if !options.DryRun {
+				err = DeleteImage(db, Image{UUID: r.UUID, Bucket: bucket, BucketPath: r.BucketPath, Status: r.Status})
+				if err != nil && err != ErrInvalidTransition {
+					report.Errors = append(report.Errors, r.UUID.String()+": "+err.Error())
+				}
+			}
+		}
+		if (r.Status == StatusUploading || r.Status == StatusProcessing) &&
+			now.Sub(r.StatusChangedAt) > options.UploadTTL {
+			report.StaleUploads = append(report.StaleUploads, r.UUID.String())
+			if !options.DryRun {
+				_, err = FailInterruptedUpload(db, r.UUID, now.Add(-options.UploadTTL))
+				if err != nil {
+					report.Errors = append(report.Errors, r.UUID.String()+": "+err.Error())
+				}
+			}
+		}
+		if r.Status == StatusUploaded && !storedPaths[r.BucketPath] {
+			report.MissingObjects = append(report.MissingObjects, r.UUID.String())
+			if !options.DryRun {
+				err = MarkImageMissing(db, r.UUID)
+				if err != nil && err != ErrInvalidTransition {
+					report.Errors = append(report.Errors, r.UUID.String()+": "+err.Error())
+				}
+			}
+		}
+	}
+
+	for _, o := range objects {
+		if knownPaths[o.Name] || strings.HasPrefix(o.Name, exportPrefix) || now.Sub(o.Updated) < options.MinObjectAge {
+			continue
+		}
+		report.OrphanObjects = append(report.OrphanObjects, o.Name)
+		if !options.DryRun {
+			err = deleteFile(bucket, o.Name)
+			if err != nil {
+				report.Errors = append(report.Errors, o.Name+": "+err.Error())
+			}
+		}
+	}
+	return report, nil
+}
+
+// Run Reconcile periodically with the options until the process exits. Reports are logged
+func RunPeriodicReconcile(db *sql.DB, bucket string, interval time.Duration, options ReconcileOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := Reconcile(db, bucket, options)
+		if err != nil {
+			log.Println("reconcile: " + err.Error())
+			continue
+		}
+		encoded, _ := json.Marshal(report)
+		log.Println("reconcile: " + string(encoded))
+	}
+}
+
+// Read the periodic reconciliation settings from the environment. The returned interval is 0 when it is disabled
+func ReconcileFromEnv() (time.Duration, ReconcileOptions) {
+	options := ReconcileOptions{
+		DryRun:       os.Getenv("RECONCILE_REPAIR") != "true",
+		CreatedTTL:   parseDurationVariable("RECONCILE_CREATED_TTL", defaultCreatedTTL),
+		UploadTTL:    parseDurationVariable("RECONCILE_UPLOAD_TTL", defaultUploadTTL),
+		MinObjectAge: defaultMinObjectAge,
+	}
+	return parseDurationVariable("RECONCILE_INTERVAL", 0), options
+}
+
+// Parse an optional duration environment variable
+func parseDurationVariable(name string, defaultValue time.Duration) time.Duration {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		panic("fatal: environment variable " + name + " must be a positive duration")
+	}
+	return duration
+}
+
+// Run the reconcile command line subcommand and print the report as JSON
+func RunReconcileCommand(args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", true, "only report the mismatches without repairing them")
+	createdTTL := flags.Duration("created-ttl", defaultCreatedTTL, "age after which CREATED images are expired")
+	uploadTTL := flags.Duration("upload-ttl", defaultUploadTTL, "age after which UPLOADING and PROCESSING images "+
+		"are marked as FAILED")
+	minObjectAge := flags.Duration("min-object-age", defaultMinObjectAge, "minimum age of an orphan object")
+	flags.Parse(args)
+
+	CheckEnvVariables()
+	db, err := NewConnectionPool()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := Reconcile(db, os.Getenv("BUCKET"), ReconcileOptions{
+		DryRun:       *dryRun,
+		CreatedTTL:   *createdTTL,
+		UploadTTL:    *uploadTTL,
+		MinObjectAge: *minObjectAge,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(&report)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Return the records of the bucket that weren't purged
+func GetReconcileRecords(db *sql.DB, bucket string) ([]reconcileRecord, error) {
+	rows, err := db.Query("SELECT UUID, bucketPath, status, createdAt, COALESCE(statusChangedAt, createdAt), "+
+		"deletedAt IS NOT NULL FROM images WHERE bucket = ? AND purgedAt IS NULL", bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]reconcileRecord, 0)
+	for rows.Next() {
+		var record reconcileRecord
+		var uuidToParse []byte
+		var status sql.NullString
+		err = rows.Scan(&uuidToParse, &record.BucketPath, &status, &record.CreatedAt, &record.StatusChangedAt,
+			&record.Deleted)
+		if err != nil {
+			return nil, err
+		}
+		err = record.UUID.UnmarshalBinary(uuidToParse)
+		if err != nil {
+			return nil, err
+		}
+		record.Status = Status(status.String)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
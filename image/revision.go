@@ -0,0 +1,238 @@
+package image
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultRevisionRetention = 10
+
+type Revision struct {
+	Image      uuid.UUID
+	Number     int
+	BucketPath string
+	Size       int64
+	Height     int32
+	Length     int32
+	CreatedAt  time.Time
+}
+
+// Return a new object path for a revision of the image. Every upload gets its own path so that the file left by a
+// failed upload can be deleted without racing the next upload of the same revision
+func revisionPath(image Image, number int) string {
+	return fmt.Sprintf("%s.%d.%s.%s", image.UUID.String(), number, uuid.New().String()[:8], image.Extension)
+}
+
+// Read the number of revisions kept per image from the REVISION_RETENTION environment variable
+func revisionRetentionFromEnv() int {
+	value := parseQuotaVariable("REVISION_RETENTION")
+	if value == 0 {
+		return defaultRevisionRetention
+	}
+	return int(value)
+}
+
+// Convert a Revision into a RevisionResponse object
+func (r Revision) toRevisionResponse(current int, url string) RevisionResponse {
+	return RevisionResponse{
+		Revision:  r.Number,
+		Current:   r.Number == current,
+		Url:       url,
+		Size:      r.Size,
+		Height:    r.Height,
+		Length:    r.Length,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+// Record the revision and make it the current file of the image, moving it from PROCESSING to UPLOADED. The deletion
+// of its file enqueued before the upload is removed, and the revisions beyond the retention are removed and their
+// files enqueued in the storage outbox in the same transaction. ErrInvalidTransition is returned if the reaper
+// already claimed the file because the upload outlived its deletion. Return the number of pruned revisions
+func CompleteUpload(db *sql.DB, image *Image, revision Revision, retention int) (int, error) {
+	if !image.Status.canTransitionTo(StatusUploaded) {
+		return 0, ErrInvalidTransition
+	}
+	imageUUID, err := image.UUID.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM storage_outbox WHERE image = ? AND bucketPath = ? AND attempts = 0 "+
+		"AND completedAt IS NULL", imageUUID, revision.BucketPath)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		tx.Rollback()
+		return 0, ErrInvalidTransition
+	}
+
+	_, err = tx.Exec("INSERT INTO image_revisions (image, revision, bucketPath, size, height, length, createdAt) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?)", imageUUID, revision.Number, revision.BucketPath, revision.Size,
+		revision.Height, revision.Length, revision.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	result, err = tx.Exec("UPDATE images SET status = ?, failureReason = '''', bucketPath = ?, revision = ?, size = ?, "+
+		"height = ?, length = ?, statusChangedAt = ?, version = version + 1 WHERE UUID = ? AND status = ? "+
+		"AND deletedAt IS NULL", StatusUploaded, revision.BucketPath, revision.Number, revision.Size, revision.Height,
+		revision.Length, time.Now().UTC(), imageUUID, image.Status)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		tx.Rollback()
+		return 0, ErrInvalidTransition
+	}
+
+	pruned, err := pruneRevisions(tx, image, retention)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	image.Status = StatusUploaded
+	image.FailureReason = ""
+	image.BucketPath = revision.BucketPath
+	image.Revision = revision.Number
+	image.Size = revision.Size
+	image.Height = revision.Height
+	image.Length = revision.Length
+	image.Version++
+	return pruned, nil
+}
+
+// Remove the oldest revisions of the image so that only the retention newest ones are kept
+func pruneRevisions(tx *sql.Tx, image *Image, retention int) (int, error) {
+	imageUUID, err := image.UUID.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query("SELECT revision, bucketPath FROM image_revisions WHERE image = ? "+
+		"ORDER BY revision DESC LIMIT 18446744073709551615 OFFSET "+strconv.Itoa(retention), imageUUID)
+	if err != nil {
+		return 0, err
+	}
+	expired := make([]Revision, 0)
+	for rows.Next() {
+		var revision Revision
+		err = rows.Scan(&revision.Number, &revision.BucketPath)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, revision)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	for _, revision := range expired {
+		_, err = tx.Exec("INSERT INTO storage_outbox (image, bucket, bucketPath, nextAttemptAt, createdAt) "+
+			"VALUES (?, ?, ?, ?, ?)", imageUUID, image.Bucket, revision.BucketPath, now, now)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec("DELETE FROM image_revisions WHERE image = ? AND revision = ?", imageUUID, revision.Number)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
+// Return the revisions of the image from the newest to the oldest
+func GetRevisions(db *sql.DB, id uuid.UUID) ([]Revision, error) {
+	uuidToGet, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT image, revision, bucketPath, size, height, length, createdAt FROM image_revisions "+
+		"WHERE image = ? ORDER BY revision DESC", uuidToGet)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]Revision, 0)
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *revision)
+	}
+	return revisions, rows.Err()
+}
+
+// Return a single revision of the image
+func GetRevision(db *sql.DB, id uuid.UUID, number int) (*Revision, error) {
+	uuidToGet, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	row := db.QueryRow("SELECT image, revision, bucketPath, size, height, length, createdAt FROM image_revisions "+
+		"WHERE image = ? AND revision = ?", uuidToGet, number)
+	return scanRevision(row)
+}
+
+// Return the object paths of every revision of the images of the bucket that weren't purged
+func GetRevisionPaths(db *sql.DB, bucket string) ([]string, error) {
+	rows, err := db.Query("SELECT r.bucketPath FROM image_revisions r JOIN images i ON r.image = i.UUID "+
+		"WHERE i.bucket = ? AND i.purgedAt IS NULL", bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := make([]string, 0)
+	for rows.Next() {
+		var path string
+		err = rows.Scan(&path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// Scan a row of the image_revisions table into a Revision object
+func scanRevision(row rowScanner) (*Revision, error) {
+	revision := &Revision{}
+	var uuidToParse []byte
+
+	err := row.Scan(&uuidToParse, &revision.Number, &revision.BucketPath, &revision.Size, &revision.Height,
+		&revision.Length, &revision.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = revision.Image.UnmarshalBinary(uuidToParse)
+	if err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
@@ -0,0 +1,184 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Handle the API request to list the revisions of an image
+func (h *Handler) HandleGetRevisions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	image, errResponse := h.getAuthorizedImage(r, ScopeImagesRead, RoleViewer)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	revisions, err := GetRevisions(h.db, image.UUID)
+	if err != nil {
+		common.RespondWithError(w, &common.GetImagesDBError)
+		return
+	}
+
+	response := make(RevisionsResponse, 0)
+	for _, revision := range revisions {
+		response = append(response, revision.toRevisionResponse(image.Revision, ""))
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to get a revision of an image including a temporary download link to its file
+func (h *Handler) HandleGetRevision(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	image, errResponse := h.getAuthorizedImage(r, ScopeImagesRead, RoleViewer)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	revision, errResponse := h.getRevision(r, image)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	url, err := generateSignedURL(image.Bucket, revision.BucketPath)
+	if err != nil {
+		common.RespondWithError(w, &common.URLGenerationError)
+		return
+	}
+
+	response := revision.toRevisionResponse(image.Revision, url)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to restore a previous revision. The file of the revision is copied into a new revision
+// that becomes the current one, so the history is never rewritten
+func (h *Handler) HandlePostRevisionRestore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	image, errResponse := h.getAuthorizedImage(r, ScopeImagesWrite, RoleEditor)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	source, errResponse := h.getRevision(r, image)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	usage, err := GetUsage(h.db, image.Owner)
+	if err != nil {
+		common.RespondWithError(w, &common.UsageDBError)
+		return
+	}
+	if !h.quota.allowsBytes(usage, 0, source.Size) {
+		common.RespondWithError(w, &common.QuotaExceededError)
+		return
+	}
+
+	previous := *image
+	errResponse = h.transitionOrRespond(image, StatusUploading)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	revision := Revision{
+		Image:      image.UUID,
+		Number:     image.Revision + 1,
+		BucketPath: revisionPath(*image, image.Revision+1),
+		Size:       source.Size,
+		Height:     source.Height,
+		Length:     source.Length,
+		CreatedAt:  time.Now().UTC(),
+	}
+	errResponse = h.reserveRevisionFile(*image, revision.BucketPath)
+	if errResponse != nil {
+		h.failUpload(image, previous, revision.BucketPath, failureUploadError)
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	err = copyObject(image.Bucket, source.BucketPath, revision.BucketPath)
+	if err != nil {
+		h.failUpload(image, previous, revision.BucketPath, failureUploadError)
+		common.RespondWithError(w, &common.FileUploadError)
+		return
+	}
+
+	// The file of the revision was already validated when it was first uploaded
+	errResponse = h.transitionOrRespond(image, StatusProcessing)
+	if errResponse != nil {
+		h.failUpload(image, previous, revision.BucketPath, failureUploadInterrupted)
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	errResponse = h.completeUpload(image, revision)
+	if errResponse != nil {
+		h.failUpload(image, previous, revision.BucketPath, failureUploadInterrupted)
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	h.emitEvent(EventImageUpdated, *image)
+
+	url, err := generateSignedURL(image.Bucket, image.BucketPath)
+	if err != nil {
+		common.RespondWithError(w, &common.URLGenerationError)
+		return
+	}
+
+	response := image.toLinkedImageResponse(url)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Return the image identified by the uuid route variable if the user has at least the required role on it
+func (h *Handler) getAuthorizedImage(r *http.Request, scope string, required Role) (*Image,
+	*common.ErrorResponseError) {
+	uuidToGet, err := uuid.Parse(mux.Vars(r)["uuid"])
+	if err != nil {
+		return nil, &common.InvalidUUIDError
+	}
+
+	username, errResponse := h.authenticate(r, scope)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+
+	image, err := GetImage(h.db, uuidToGet)
+	if err != nil || image.Status == StatusTrashed {
+		return nil, &common.ImageNotFoundError
+	}
+	errResponse = h.authorizeImage(username, image, required, &common.UserPermissionDeniedError)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	return image, nil
+}
+
+// Return the revision of the image identified by the revision route variable
+func (h *Handler) getRevision(r *http.Request, image *Image) (*Revision, *common.ErrorResponseError) {
+	number, err := strconv.Atoi(mux.Vars(r)["revision"])
+	if err != nil || number < 1 {
+		return nil, &common.RevisionNotFoundError
+	}
+
+	revision, err := GetRevision(h.db, image.UUID, number)
+	if err != nil {
+		return nil, &common.RevisionNotFoundError
+	}
+	return revision, nil
+}
@@ -0,0 +1,162 @@
+package image
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+const (
+	revocationCacheTTL = 30 * time.Second
+)
+
+type cachedRevocation struct {
+	revoked   bool
+	fetchedAt time.Time
+}
+
+type cachedWatermark struct {
+	notBefore time.Time
+	fetchedAt time.Time
+}
+
+// In-memory cache in front of the revocation tables so that every authenticated request doesn't hit the database.
+// Revocations made by another replica are picked up once the cached entry expires
+type revocationCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	tokens     map[string]cachedRevocation
+	watermarks map[string]cachedWatermark
+}
+
+// Return an empty revocation cache whose entries are valid for the ttl
+func newRevocationCache(ttl time.Duration) *revocationCache {
+	return &revocationCache{
+		ttl:        ttl,
+		tokens:     make(map[string]cachedRevocation),
+		watermarks: make(map[string]cachedWatermark),
+	}
+}
+
+// Return true if the token with the jti was revoked
+func (c *revocationCache) isRevoked(db *sql.DB, jti string) (bool, error) {
+	c.mu.Lock()
+	entry, ok := c.tokens[jti]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < c.ttl {
+		return entry.revoked, nil
+	}
+
+	revoked, err := IsTokenRevoked(db, jti)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[jti] = cachedRevocation{revoked: revoked, fetchedAt: time.Now()}
+	return revoked, nil
+}
+
+// Return the time before which the tokens issued to the user are invalid. The zero time is returned if none is set
+func (c *revocationCache) watermark(db *sql.DB, username string) (time.Time, error) {
+	c.mu.Lock()
+	entry, ok := c.watermarks[username]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < c.ttl {
+		return entry.notBefore, nil
+	}
+
+	notBefore, err := GetTokenWatermark(db, username)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watermarks[username] = cachedWatermark{notBefore: notBefore, fetchedAt: time.Now()}
+	return notBefore, nil
+}
+
+// Drop the cached revocation of the jti so that it is applied immediately on this replica
+func (c *revocationCache) invalidateToken(jti string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, jti)
+}
+
+// Drop the cached watermark of the user so that it is applied immediately on this replica
+func (c *revocationCache) invalidateWatermark(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.watermarks, username)
+}
+
+// Remove the expired entries every ttl so that the cache only holds the tokens and users seen recently. This
+// function never returns
+func (c *revocationCache) Run() {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		c.evictExpired()
+		c.mu.Unlock()
+	}
+}
+
+// Remove the expired entries. The caller must hold the lock
+func (c *revocationCache) evictExpired() {
+	for jti, entry := range c.tokens {
+		if time.Since(entry.fetchedAt) >= c.ttl {
+			delete(c.tokens, jti)
+		}
+	}
+	for username, entry := range c.watermarks {
+		if time.Since(entry.fetchedAt) >= c.ttl {
+			delete(c.watermarks, username)
+		}
+	}
+}
+
+// Create a DB record revoking the token with the jti. Revocations that outlived their token are cleaned up
+func RevokeToken(db *sql.DB, jti string, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	_, err := db.Exec("INSERT INTO revoked_tokens (jti, revokedAt, expiresAt) VALUES (?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE expiresAt = VALUES(expiresAt)", jti, now, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM revoked_tokens WHERE expiresAt < ?", now)
+	return err
+}
+
+// Return true if a revocation record exists for the jti
+func IsTokenRevoked(db *sql.DB, jti string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?", jti).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Invalidate every token issued to the user before notBefore
+func SetTokenWatermark(db *sql.DB, username string, notBefore time.Time) error {
+	_, err := db.Exec("INSERT INTO token_watermarks (owner, notBefore) VALUES (?, ?) "+
+		"ON DUPLICATE KEY UPDATE notBefore = VALUES(notBefore)", username, notBefore.UTC())
+	return err
+}
+
+// Return the watermark of the user or the zero time if none is set
+func GetTokenWatermark(db *sql.DB, username string) (time.Time, error) {
+	var notBefore time.Time
+	err := db.QueryRow("SELECT notBefore FROM token_watermarks WHERE owner = ?", username).Scan(&notBefore)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return notBefore, nil
+}
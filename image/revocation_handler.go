@@ -0,0 +1,60 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Handle the admin API request to revoke a token by jti or every token of a user issued before a time
+func (h *Handler) HandlePostRevocation(w http.ResponseWriter, r *http.Request) {
+	var request RevokeTokenRequest
+	w.Header().Set("Content-Type", "application/json")
+	errResponse := decodeStrictJSON(r.Body, &request)
+	if errResponse == nil && ((request.Jti == "" && request.Username == "") || len(request.Jti) > 64) {
+		errResponse = &common.InvalidRequestBodyError
+	}
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	_, errResponse = h.authenticateAdmin(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	var err error
+	response := RevokeTokenResponse{Jti: request.Jti, Username: request.Username}
+	if request.Jti != "" {
+		// A token can't outlive its validity so the revocation record can be dropped after it
+		err = RevokeToken(h.db, request.Jti, time.Now().UTC().Add(common.TokenValidity))
+		if err != nil {
+			common.RespondWithError(w, &common.TokenRevocationDBError)
+			return
+		}
+		h.revocations.invalidateToken(request.Jti)
+	}
+
+	if request.Username != "" {
+		issuedBefore := time.Now().UTC()
+		if request.IssuedBefore != nil {
+			issuedBefore = request.IssuedBefore.UTC()
+		}
+		err = SetTokenWatermark(h.db, request.Username, issuedBefore)
+		if err != nil {
+			common.RespondWithError(w, &common.TokenRevocationDBError)
+			return
+		}
+		h.revocations.invalidateWatermark(request.Username)
+		response.IssuedBefore = &issuedBefore
+	}
+
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
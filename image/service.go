@@ -0,0 +1,299 @@
+package image
+
+import (
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Create the record of an image for the user, inside an organisation if one is requested
+func (h *Handler) createImage(username string, request CreateImageRequest) (*Image, *common.ErrorResponseError) {
+	if params := request.validate(); len(params) > 0 {
+		return nil, invalidParamsError(params)
+	}
+	request.Extension = strings.ToLower(request.Extension)
+
+	var organisation *uuid.UUID
+	if request.Organisation != "" {
+		organisationID, err := uuid.Parse(request.Organisation)
+		if err != nil {
+			return nil, &common.InvalidUUIDError
+		}
+		errResponse := h.authorizeOrganisation(username, organisationID, RoleEditor)
+		if errResponse != nil {
+			return nil, errResponse
+		}
+		organisation = &organisationID
+	}
+
+	image := request.toImage(username, organisation)
+	err := CreateImage(h.db, image, h.quota.MaxObjects)
+	if err == ErrQuotaExceeded {
+		return nil, &common.QuotaExceededError
+	} else if err != nil {
+		return nil, &common.DatabaseInsertionError
+	}
+	h.emitEvent(EventImageCreated, image)
+	return &image, nil
+}
+
+// Return an uploaded image the user can view along with a temporary download link
+func (h *Handler) getLinkedImage(username string, id uuid.UUID) (*Image, string, *common.ErrorResponseError) {
+	image, errResponse := h.getUploadedImage(username, id)
+	if errResponse != nil {
+		return nil, "", errResponse
+	}
+
+	url, err := generateSignedURL(image.Bucket, image.BucketPath)
+	if err != nil {
+		return nil, "", &common.URLGenerationError
+	}
+	return image, url, nil
+}
+
+// Return an image the user can view if its file is uploaded
+func (h *Handler) getUploadedImage(username string, id uuid.UUID) (*Image, *common.ErrorResponseError) {
+	image, errResponse := h.getViewableImage(username, id)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+
+	if image.Status == StatusFailed {
+		errResponse := common.ImageUploadFailedError
+		errResponse.Detail = image.FailureReason
+		return nil, &errResponse
+	}
+	if image.Status != StatusUploaded {
+		return nil, &common.ImageNotUploadedError
+	}
+	return image, nil
+}
+
+// Return an image the user can view, whatever the status of its upload
+func (h *Handler) getViewableImage(username string, id uuid.UUID) (*Image, *common.ErrorResponseError) {
+	image, err := GetImage(h.db, id)
+	if err != nil || image.Status == StatusTrashed {
+		return nil, &common.ImageNotFoundError
+	}
+
+	errResponse := h.authorizeImage(username, image, RoleViewer, &common.UserPermissionDeniedError)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	return image, nil
+}
+
+// Return an image the user can edit. deniedErr is returned if the user can only view it
+func (h *Handler) getEditableImage(username string, id uuid.UUID, deniedErr *common.ErrorResponseError) (*Image,
+	*common.ErrorResponseError) {
+	image, err := GetImage(h.db, id)
+	if err != nil || image.Status == StatusTrashed {
+		return nil, &common.ImageNotFoundError
+	}
+
+	errResponse := h.authorizeImage(username, image, RoleEditor, deniedErr)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	return image, nil
+}
+
+// Return the personal images of the user, or the images of the organisation if one is requested
+func (h *Handler) listImages(username, organisationParam string) ([]Image, *common.ErrorResponseError) {
+	var images []Image
+	var err error
+	if organisationParam != "" {
+		organisation, parseErr := uuid.Parse(organisationParam)
+		if parseErr != nil {
+			return nil, &common.InvalidUUIDError
+		}
+		errResponse := h.authorizeOrganisation(username, organisation, RoleViewer)
+		if errResponse != nil {
+			return nil, errResponse
+		}
+		images, err = GetOrganisationImages(h.db, organisation)
+	} else {
+		images, err = GetImages(h.db, username)
+	}
+	if err != nil {
+		return nil, &common.GetImagesDBError
+	}
+	return images, nil
+}
+
+// Return a page of the personal images of the user, or of the images of the organisation if one is requested,
+// starting after the cursor. The second value is true if there are more images after the page
+func (h *Handler) listImagesPage(username, organisationParam string, limit int, after string) ([]Image, bool,
+	*common.ErrorResponseError) {
+	if limit < 1 || limit > maxPageSize {
+		return nil, false, &common.InvalidPageSizeError
+	}
+	var cursor *ImageCursor
+	if after != "" {
+		var err error
+		cursor, err = decodeImageCursor(after)
+		if err != nil {
+			return nil, false, &common.InvalidCursorError
+		}
+	}
+
+	var organisation *uuid.UUID
+	if organisationParam != "" {
+		organisationID, err := uuid.Parse(organisationParam)
+		if err != nil {
+			return nil, false, &common.InvalidUUIDError
+		}
+		errResponse := h.authorizeOrganisation(username, organisationID, RoleViewer)
+		if errResponse != nil {
+			return nil, false, errResponse
+		}
+		organisation = &organisationID
+	}
+
+	// One more image is read to know if there is a next page
+	images, err := GetImagesPage(h.db, username, organisation, cursor, limit+1)
+	if err != nil {
+		return nil, false, &common.GetImagesDBError
+	}
+	if len(images) > limit {
+		return images[:limit], true, nil
+	}
+	return images, false, nil
+}
+
+// Apply the changes of the request to an image the user can edit. The image is only changed if its ETag matches
+// ifMatch, unless ifMatch is empty
+func (h *Handler) updateImage(username string, id uuid.UUID, request UpdateImageRequest, ifMatch string) (*Image,
+	*common.ErrorResponseError) {
+	if reason := imageNameProblem(request.Name); reason != "" {
+		errResponse := common.InvalidImageNameError
+		errResponse.InvalidParams = []common.InvalidParam{{Name: "name", Reason: reason}}
+		return nil, &errResponse
+	}
+	image, errResponse := h.getEditableImage(username, id, &common.UserPermissionDeniedError)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	if !satisfiesIfMatch(ifMatch, *image) {
+		return nil, &common.PreconditionFailedError
+	}
+
+	image.Name = request.Name
+	err := UpdateImage(h.db, image)
+	if err == ErrStaleImage && ifMatch != "" {
+		return nil, &common.PreconditionFailedError
+	} else if err == ErrStaleImage {
+		return nil, &common.ImageStatusConflictError
+	} else if err != nil {
+		return nil, &common.ImageUpdateDBError
+	}
+	h.emitEvent(EventImageUpdated, *image)
+	return image, nil
+}
+
+// Move an image the user can edit to the trash. The image is only trashed if its ETag matches ifMatch, unless ifMatch
+// is empty
+func (h *Handler) trashImage(username string, id uuid.UUID, ifMatch string) (*Image, *common.ErrorResponseError) {
+	image, errResponse := h.getEditableImage(username, id, &common.UserPermissionDeniedError)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	if !satisfiesIfMatch(ifMatch, *image) {
+		return nil, &common.PreconditionFailedError
+	}
+
+	err := TrashImage(h.db, image)
+	if err == ErrInvalidTransition {
+		return nil, &common.ImageStatusConflictError
+	} else if err != nil {
+		return nil, &common.DBDeletionError
+	}
+	h.emitEvent(EventImageDeleted, *image)
+	return image, nil
+}
+
+// Store the file as a new revision of the image, validate it and make it the current file of the image. The file is
+// read again from the start after being uploaded so that it can be processed
+func (h *Handler) uploadImage(image *Image, file io.ReadSeeker, size int64) *common.ErrorResponseError {
+	usage, err := GetUsage(h.db, image.Owner)
+	if err != nil {
+		return &common.UsageDBError
+	}
+	// The usage counts every kept revision and the upload adds a new one, so it adds to the stored bytes
+	if !h.quota.allowsBytes(usage, 0, size) {
+		return &common.QuotaExceededError
+	}
+
+	// A failed upload puts the image back to its previous file
+	previous := *image
+	errResponse := h.transitionOrRespond(image, StatusUploading)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	// Each upload is stored as a new revision so that the previous files are kept
+	revision := Revision{
+		Image:      image.UUID,
+		Number:     image.Revision + 1,
+		BucketPath: revisionPath(*image, image.Revision+1),
+		Size:       size,
+		CreatedAt:  time.Now().UTC(),
+	}
+	errResponse = h.reserveRevisionFile(*image, revision.BucketPath)
+	if errResponse != nil {
+		h.failUpload(image, previous, revision.BucketPath, failureUploadError)
+		return errResponse
+	}
+	err = uploadToBucket(file, image.Bucket, revision.BucketPath)
+	if err != nil {
+		h.failUpload(image, previous, revision.BucketPath, failureUploadError)
+		return &common.FileUploadError
+	}
+
+	errResponse = h.transitionOrRespond(image, StatusProcessing)
+	if errResponse != nil {
+		h.failUpload(image, previous, revision.BucketPath, failureUploadInterrupted)
+		return errResponse
+	}
+
+	if reason := processImage(file, image); reason != "" {
+		h.failUpload(image, previous, revision.BucketPath, reason)
+		errResponse := common.ImageProcessingFailedError
+		errResponse.Detail = reason
+		return &errResponse
+	}
+
+	revision.Height = image.Height
+	revision.Length = image.Length
+	errResponse = h.completeUpload(image, revision)
+	if errResponse != nil {
+		h.failUpload(image, previous, revision.BucketPath, failureUploadInterrupted)
+		return errResponse
+	}
+	h.emitEvent(EventImageUploaded, *image)
+	return nil
+}
+
+// Append the event to the log, signal the event streams of the owner of the image and notify its webhooks. The event
+// is only logged if it can't be appended since the operation that triggered it already succeeded
+func (h *Handler) emitEvent(eventType string, image Image) {
+	event := newEvent(eventType, image)
+	err := AppendEvent(h.db, &event)
+	if err != nil {
+		log.Printf("appending the %s event of %s: %v", eventType, image.UUID, err)
+		return
+	}
+	h.events.publish(event.Owner)
+
+	enqueued, err := EnqueueWebhookDeliveries(h.db, event)
+	if err != nil {
+		log.Printf("enqueuing the %s event of %s: %v", eventType, image.UUID, err)
+	}
+	if enqueued > 0 {
+		h.webhooks.wake()
+	}
+}
@@ -0,0 +1,132 @@
+package image
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	// The record exists but no file was uploaded yet
+	StatusCreated Status = "CREATED"
+	// A file is being uploaded to the storage
+	StatusUploading Status = "UPLOADING"
+	// The file is stored and is being validated
+	StatusProcessing Status = "PROCESSING"
+	// The file is stored and valid
+	StatusUploaded Status = "UPLOADED"
+	// The upload or the validation failed, or the file went missing. The reason is kept in FailureReason
+	StatusFailed Status = "FAILED"
+	// The image is in the trash. It can be restored to the status it had before until it is purged
+	StatusTrashed Status = "TRASHED"
+	// The record is soft deleted and its file is waiting to be deleted by the StorageReaper
+	StatusDeleting Status = "DELETING"
+)
+
+// Statuses each status can transition to. Every status except DELETING can be deleted. The images are restored
+// from TRASHED to the status they had before being trashed, except for the uploads interrupted by the trash which
+// are restored as FAILED. A failed upload of an UPLOADED image goes back to UPLOADED with its previous file
+var statusTransitions = map[Status][]Status{
+	StatusCreated:    {StatusUploading, StatusTrashed, StatusDeleting},
+	StatusUploading:  {StatusProcessing, StatusUploaded, StatusFailed, StatusTrashed, StatusDeleting},
+	StatusProcessing: {StatusUploaded, StatusFailed, StatusTrashed, StatusDeleting},
+	StatusUploaded:   {StatusUploading, StatusFailed, StatusTrashed, StatusDeleting},
+	StatusFailed:     {StatusUploading, StatusTrashed, StatusDeleting},
+	StatusTrashed:    {StatusCreated, StatusUploaded, StatusFailed, StatusDeleting},
+	StatusDeleting:   {},
+}
+
+var ErrInvalidTransition = errors.New("error the image can't transition to that status")
+
+// Return true if the transition from s to the status is defined
+func (s Status) canTransitionTo(to Status) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Move the image to the status and persist its mutable fields in a conditional update. ErrInvalidTransition is
+// returned if the transition isn't defined or if the status changed concurrently since the image was read
+func TransitionImage(db *sql.DB, image *Image, to Status, failureReason string) error {
+	if !image.Status.canTransitionTo(to) {
+		return ErrInvalidTransition
+	}
+	uuidToUpdate, err := image.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	failureReason = truncateRunes(failureReason, 255)
+
+	result, err := db.Exec("UPDATE images SET status = ?, failureReason = ?, height = ?, length = ?, "+
+		"bucketPath = ?, size = ?, statusChangedAt = ?, version = version + 1 WHERE UUID = ? AND status = ? "+
+		"AND deletedAt IS NULL", to, failureReason, image.Height, image.Length, image.BucketPath, image.Size,
+		time.Now().UTC(), uuidToUpdate, image.Status)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidTransition
+	}
+
+	image.Status = to
+	image.FailureReason = failureReason
+	image.Version++
+	return nil
+}
+
+// Mark an uploaded image whose file doesn't exist anymore as FAILED
+func MarkImageMissing(db *sql.DB, id uuid.UUID) error {
+	image, err := GetImage(db, id)
+	if err != nil {
+		return err
+	}
+	if image.Status != StatusUploaded {
+		return nil
+	}
+	return TransitionImage(db, image, StatusFailed, failureMissingFile)
+}
+
+// Mark the image as FAILED if it is still UPLOADING or PROCESSING since before the time, which happens when the
+// replica handling its upload stopped. false is returned if the image isn't stuck anymore
+func FailInterruptedUpload(db *sql.DB, id uuid.UUID, changedBefore time.Time) (bool, error) {
+	uuidToFail, err := id.MarshalBinary()
+	if err != nil {
+		return false, err
+	}
+	result, err := db.Exec("UPDATE images SET status = ?, failureReason = ?, statusChangedAt = ?, "+
+		"version = version + 1 WHERE UUID = ? AND status IN (?, ?) AND COALESCE(statusChangedAt, createdAt) < ? "+
+		"AND deletedAt IS NULL", StatusFailed, failureUploadInterrupted, time.Now().UTC(), uuidToFail,
+		StatusUploading, StatusProcessing, changedBefore)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// Return the first n characters of the string
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+const (
+	failureUploadError       = "The file couldn't be uploaded to the storage, please retry the upload"
+	failureUploadInterrupted = "The upload was interrupted, please upload the file again"
+	failureNotAnImage        = "The uploaded file isn't an image"
+	failureMissingFile       = "The uploaded file is missing from the storage, please upload it again"
+)
@@ -0,0 +1,215 @@
+package image
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	reaperInterval   = 30 * time.Second
+	reaperBatchSize  = 50
+	reaperLease      = 5 * time.Minute
+	reaperMaxBackoff = time.Hour
+)
+
+type outboxEntry struct {
+	ID            int64
+	Image         uuid.UUID
+	Bucket        string
+	BucketPath    string
+	Attempts      int
+	NextAttemptAt time.Time
+}
+
+// Background worker deleting the files enqueued in the storage outbox. Deletions are retried with an exponential
+// backoff until they succeed so that the database and the storage never diverge
+type StorageReaper struct {
+	db      *sql.DB
+	wakeups chan struct{}
+}
+
+// Return a StorageReaper working on the outbox of the database
+func NewStorageReaper(db *sql.DB) *StorageReaper {
+	return &StorageReaper{db: db, wakeups: make(chan struct{}, 1)}
+}
+
+// Process the outbox periodically or as soon as the reaper is woken up. This function never returns
+func (r *StorageReaper) Run() {
+	ticker := time.NewTicker(reaperInterval)
+	defer ticker.Stop()
+	for {
+		r.reapDueEntries()
+		select {
+		case <-ticker.C:
+		case <-r.wakeups:
+		}
+	}
+}
+
+// Ask the reaper to process the outbox without waiting for the next tick
+func (r *StorageReaper) wake() {
+	select {
+	case r.wakeups <- struct{}{}:
+	default:
+	}
+}
+
+// Delete the files of the due outbox entries until none is left
+func (r *StorageReaper) reapDueEntries() {
+	for {
+		entries, err := GetDueOutboxEntries(r.db, reaperBatchSize)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+
+		for _, entry := range entries {
+			r.reap(entry)
+		}
+		if len(entries) < reaperBatchSize {
+			return
+		}
+	}
+}
+
+// Delete the file of a single outbox entry. Another replica may have claimed the entry, in which case it is skipped
+func (r *StorageReaper) reap(entry outboxEntry) {
+	claimed, err := ClaimOutboxEntry(r.db, entry, time.Now().UTC().Add(reaperLease))
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	err = deleteFile(entry.Bucket, entry.BucketPath)
+	if err != nil {
+		log.Printf("storage reaper: deleting %s/%s (attempt %d): %v", entry.Bucket, entry.BucketPath,
+			entry.Attempts+1, err)
+		err = RetryOutboxEntry(r.db, entry.ID, err.Error(), time.Now().UTC().Add(outboxBackoff(entry.Attempts+1)))
+		if err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+
+	err = CompleteOutboxEntry(r.db, entry)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Return the delay before retrying an entry after the number of failed attempts
+func outboxBackoff(attempts int) time.Duration {
+	delay := time.Duration(math.Pow(2, float64(attempts))) * 10 * time.Second
+	if delay <= 0 || delay > reaperMaxBackoff {
+		return reaperMaxBackoff
+	}
+	return delay
+}
+
+// Enqueue the deletion of a file of the image at deleteAt. The uploads enqueue their file before writing it and
+// CompleteUpload removes the entry once the file is recorded as a revision, so that a file is never left in the
+// bucket without a record
+func EnqueueFileDeletion(db *sql.DB, image Image, bucketPath string, deleteAt time.Time) error {
+	imageUUID, err := image.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO storage_outbox (image, bucket, bucketPath, nextAttemptAt, createdAt) "+
+		"VALUES (?, ?, ?, ?, ?)", imageUUID, image.Bucket, bucketPath, deleteAt, time.Now().UTC())
+	return err
+}
+
+// Make the deletion of a file enqueued by EnqueueFileDeletion due now
+func ExpediteFileDeletion(db *sql.DB, image Image, bucketPath string) error {
+	imageUUID, err := image.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE storage_outbox SET nextAttemptAt = ? WHERE image = ? AND bucketPath = ? "+
+		"AND attempts = 0 AND completedAt IS NULL", time.Now().UTC(), imageUUID, bucketPath)
+	return err
+}
+
+// Return the outbox entries that aren't completed and whose next attempt is due
+func GetDueOutboxEntries(db *sql.DB, limit int) ([]outboxEntry, error) {
+	rows, err := db.Query("SELECT id, image, bucket, bucketPath, attempts, nextAttemptAt FROM storage_outbox "+
+		"WHERE completedAt IS NULL AND nextAttemptAt <= ? ORDER BY nextAttemptAt LIMIT ?", time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]outboxEntry, 0)
+	for rows.Next() {
+		var entry outboxEntry
+		var uuidToParse []byte
+		err = rows.Scan(&entry.ID, &uuidToParse, &entry.Bucket, &entry.BucketPath, &entry.Attempts,
+			&entry.NextAttemptAt)
+		if err != nil {
+			return nil, err
+		}
+		err = entry.Image.UnmarshalBinary(uuidToParse)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Claim the entry until leaseUntil. Return false if another worker claimed it first
+func ClaimOutboxEntry(db *sql.DB, entry outboxEntry, leaseUntil time.Time) (bool, error) {
+	result, err := db.Exec("UPDATE storage_outbox SET nextAttemptAt = ?, attempts = attempts + 1 "+
+		"WHERE id = ? AND attempts = ? AND completedAt IS NULL", leaseUntil, entry.ID, entry.Attempts)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Record the failure of the last attempt and schedule the next one
+func RetryOutboxEntry(db *sql.DB, id int64, lastError string, nextAttemptAt time.Time) error {
+	if len(lastError) > 255 {
+		lastError = lastError[:255]
+	}
+	_, err := db.Exec("UPDATE storage_outbox SET lastError = ?, nextAttemptAt = ? WHERE id = ?",
+		lastError, nextAttemptAt, id)
+	return err
+}
+
+// Mark the entry as completed and the image as purged once none of its files is left to delete
+func CompleteOutboxEntry(db *sql.DB, entry outboxEntry) error {
+	imageUUID, err := entry.Image.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = tx.Exec("UPDATE storage_outbox SET completedAt = ? WHERE id = ?", now, entry.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE images SET purgedAt = ? WHERE UUID = ? AND deletedAt IS NOT NULL AND NOT EXISTS "+
+		"(SELECT id FROM storage_outbox WHERE image = ? AND completedAt IS NULL)", now, imageUUID, imageUUID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,169 @@
+package image
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTrashRetention = 30 * 24 * time.Hour
+	trashPurgeInterval    = 10 * time.Minute
+)
+
+// Move the image to the trash and remember its status so that it can be restored. ErrInvalidTransition is returned
+// if the image can't be trashed from its status or if its status changed concurrently
+func TrashImage(db *sql.DB, image *Image) error {
+	if !image.Status.canTransitionTo(StatusTrashed) {
+		return ErrInvalidTransition
+	}
+	uuidToTrash, err := image.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// The upload of an image trashed while it is in progress can't complete, so the image is restored as FAILED
+	trashedFrom, failureReason := image.Status, image.FailureReason
+	if trashedFrom == StatusUploading || trashedFrom == StatusProcessing {
+		trashedFrom, failureReason = StatusFailed, failureUploadInterrupted
+	}
+
+	now := time.Now().UTC()
+	result, err := db.Exec("UPDATE images SET status = ?, trashedFrom = ?, trashedAt = ?, failureReason = ?, "+
+		"statusChangedAt = ?, version = version + 1 WHERE UUID = ? AND status = ? AND deletedAt IS NULL",
+		StatusTrashed, trashedFrom, now, failureReason, now, uuidToTrash, image.Status)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return ErrInvalidTransition
+	}
+
+	image.Status = StatusTrashed
+	image.FailureReason = failureReason
+	image.TrashedAt = sql.NullTime{Time: now, Valid: true}
+	image.Version++
+	return nil
+}
+
+// Move the image out of the trash back to the status it had before being trashed
+func RestoreImage(db *sql.DB, image *Image) error {
+	uuidToRestore, err := image.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var trashedFrom Status
+	err = db.QueryRow("SELECT trashedFrom FROM images WHERE UUID = ?", uuidToRestore).Scan(&trashedFrom)
+	if err != nil {
+		return err
+	}
+	if image.Status != StatusTrashed || !image.Status.canTransitionTo(trashedFrom) {
+		return ErrInvalidTransition
+	}
+
+	result, err := db.Exec("UPDATE images SET status = ?, trashedFrom = '', trashedAt = NULL, statusChangedAt = ?, "+
+		"version = version + 1 WHERE UUID = ? AND status = ? AND deletedAt IS NULL", trashedFrom, time.Now().UTC(),
+		uuidToRestore, StatusTrashed)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return ErrInvalidTransition
+	}
+
+	image.Status = trashedFrom
+	image.TrashedAt = sql.NullTime{}
+	image.Version++
+	return nil
+}
+
+// Return the record(s) of the personal images of the user that are in the trash
+func GetTrashedImages(db *sql.DB, username string) ([]Image, error) {
+	rows, err := db.Query("SELECT "+imageColumns+" FROM images WHERE owner = ? AND organisation IS NULL "+
+		"AND status = ? AND deletedAt IS NULL ORDER BY trashedAt DESC LIMIT 500", username, StatusTrashed)
+	if err != nil {
+		return nil, err
+	}
+	return scanImages(rows)
+}
+
+// Return the record(s) of the images of the organisation that are in the trash
+func GetOrganisationTrashedImages(db *sql.DB, organisation uuid.UUID) ([]Image, error) {
+	uuidToGet, err := organisation.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+imageColumns+" FROM images WHERE organisation = ? AND status = ? "+
+		"AND deletedAt IS NULL ORDER BY trashedAt DESC LIMIT 500", uuidToGet, StatusTrashed)
+	if err != nil {
+		return nil, err
+	}
+	return scanImages(rows)
+}
+
+// Return the images that were trashed before the time
+func GetExpiredTrashedImages(db *sql.DB, trashedBefore time.Time, limit int) ([]Image, error) {
+	rows, err := db.Query("SELECT "+imageColumns+" FROM images WHERE status = ? AND trashedAt < ? "+
+		"AND deletedAt IS NULL LIMIT ?", StatusTrashed, trashedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanImages(rows)
+}
+
+// Background worker permanently deleting the images that stayed in the trash for longer than the retention
+type TrashPurger struct {
+	db        *sql.DB
+	reaper    *StorageReaper
+	retention time.Duration
+}
+
+// Return a TrashPurger handing the purged images over to the reaper
+func NewTrashPurger(db *sql.DB, reaper *StorageReaper, retention time.Duration) *TrashPurger {
+	return &TrashPurger{db: db, reaper: reaper, retention: retention}
+}
+
+// Purge the expired images periodically. This function never returns
+func (p *TrashPurger) Run() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		p.purgeExpired()
+		<-ticker.C
+	}
+}
+
+// Delete every image whose retention in the trash expired
+func (p *TrashPurger) purgeExpired() {
+	for {
+		images, err := GetExpiredTrashedImages(p.db, time.Now().UTC().Add(-p.retention), reaperBatchSize)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+
+		for _, image := range images {
+			// A concurrent restore makes the deletion fail with ErrInvalidTransition, in which case the image is kept
+			err = DeleteImage(p.db, image)
+			if err != nil && err != ErrInvalidTransition {
+				log.Println(err.Error())
+				return
+			}
+		}
+		if len(images) > 0 {
+			p.reaper.wake()
+		}
+		if len(images) < reaperBatchSize {
+			return
+		}
+	}
+}
+
+// Return the time at which the trashed image will be purged
+func (i Image) purgeAt(retention time.Duration) time.Time {
+	return i.TrashedAt.Time.Add(retention)
+}
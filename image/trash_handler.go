@@ -0,0 +1,132 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+// Handle the API request to list the images in the trash of the user or of one of its organisations
+func (h *Handler) HandleGetTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, errResponse := h.authenticate(r, ScopeImagesRead)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	var images []Image
+	var err error
+	if organisationParam := r.URL.Query().Get("organisation"); organisationParam != "" {
+		organisation, err := uuid.Parse(organisationParam)
+		if err != nil {
+			common.RespondWithError(w, &common.InvalidUUIDError)
+			return
+		}
+		errResponse = h.authorizeOrganisation(username, organisation, RoleViewer)
+		if errResponse != nil {
+			common.RespondWithError(w, errResponse)
+			return
+		}
+		images, err = GetOrganisationTrashedImages(h.db, organisation)
+	} else {
+		images, err = GetTrashedImages(h.db, username)
+	}
+	if err != nil {
+		common.RespondWithError(w, &common.GetImagesDBError)
+		return
+	}
+
+	response := make(UnlinkedImagesResponse, 0)
+	for _, image := range images {
+		response = append(response, image.toTrashedImageResponse(h.trashRetention))
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to restore an image from the trash
+func (h *Handler) HandlePostRestore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	image, errResponse := h.getTrashedImage(r, ScopeImagesDelete)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	err := RestoreImage(h.db, image)
+	if err == ErrInvalidTransition {
+		common.RespondWithError(w, &common.ImageNotInTrashError)
+		return
+	} else if err != nil {
+		common.RespondWithError(w, &common.DatabaseInsertionError)
+		return
+	}
+	h.emitEvent(EventImageUpdated, *image)
+
+	response := image.toUnlinkedImageResponse()
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to permanently delete an image from the trash without waiting for the retention
+func (h *Handler) HandleDeleteTrashedImage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	image, errResponse := h.getTrashedImage(r, ScopeImagesDelete)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	err := DeleteImage(h.db, *image)
+	if err == ErrInvalidTransition {
+		common.RespondWithError(w, &common.ImageNotInTrashError)
+		return
+	} else if err != nil {
+		common.RespondWithError(w, &common.DBDeletionError)
+		return
+	}
+	h.reaper.wake()
+
+	image.Status = StatusDeleting
+	h.emitEvent(EventImageDeleted, *image)
+	response := image.toUnlinkedImageResponse()
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Return the trashed image identified by the uuid route variable if the user is allowed to manage it
+func (h *Handler) getTrashedImage(r *http.Request, scope string) (*Image, *common.ErrorResponseError) {
+	uuidToGet, err := uuid.Parse(mux.Vars(r)["uuid"])
+	if err != nil {
+		return nil, &common.InvalidUUIDError
+	}
+
+	username, errResponse := h.authenticate(r, scope)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+
+	image, err := GetImage(h.db, uuidToGet)
+	if err != nil {
+		return nil, &common.ImageNotFoundError
+	}
+	errResponse = h.authorizeImage(username, image, RoleEditor, &common.UserPermissionDeniedError)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	if image.Status != StatusTrashed {
+		return nil, &common.ImageNotInTrashError
+	}
+	return image, nil
+}
@@ -0,0 +1,101 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+const (
+	// Number of characters of the name column of the images
+	maxImageNameLength = 64
+	// Largest height or length of an image, which is the largest one a JPEG file can declare
+	maxImageDimension = 65535
+)
+
+// Return every field of the request that is invalid
+func (request CreateImageRequest) validate() []common.InvalidParam {
+	var params []common.InvalidParam
+	if reason := imageNameProblem(request.Name); reason != "" {
+		params = append(params, common.InvalidParam{Name: "name", Reason: reason})
+	}
+	if request.Extension == "" {
+		params = append(params, common.InvalidParam{Name: "extension", Reason: "is required"})
+	} else if !isDeclarableExtension(request.Extension) {
+		params = append(params, common.InvalidParam{Name: "extension", Reason: "must be one of " +
+			strings.Join(imageExtensionList(), ", ") + " in lower or upper case"})
+	}
+	// The dimensions are optional, 0 meaning they are read from the file when it is uploaded
+	if request.Height < 0 || request.Height > maxImageDimension {
+		params = append(params, common.InvalidParam{Name: "height", Reason: "must be between 0 and " +
+			strconv.Itoa(maxImageDimension)})
+	}
+	if request.Length < 0 || request.Length > maxImageDimension {
+		params = append(params, common.InvalidParam{Name: "length", Reason: "must be between 0 and " +
+			strconv.Itoa(maxImageDimension)})
+	}
+	if request.Organisation != "" {
+		if _, err := uuid.Parse(request.Organisation); err != nil {
+			params = append(params, common.InvalidParam{Name: "organisation", Reason: "must be a uuid"})
+		}
+	}
+	return params
+}
+
+// Return why the name can't be the name of an image, or an empty string if it is valid
+func imageNameProblem(name string) string {
+	switch {
+	case name == "":
+		return "is required"
+	case !utf8.ValidString(name):
+		return "must be valid UTF-8"
+	case utf8.RuneCountInString(name) > maxImageNameLength:
+		return "must be at most " + strconv.Itoa(maxImageNameLength) + " characters long"
+	case strings.TrimSpace(name) != name:
+		return "must not start or end with a space"
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "must not contain control characters"
+		}
+	}
+	return ""
+}
+
+// Return the InvalidRequestBodyError listing the invalid parts of the request
+func invalidParamsError(params []common.InvalidParam) *common.ErrorResponseError {
+	reasons := make([]string, 0, len(params))
+	for _, param := range params {
+		name := param.Name
+		if name == "" {
+			name = "the body"
+		}
+		reasons = append(reasons, name+" "+param.Reason)
+	}
+	errResponse := common.InvalidRequestBodyError
+	errResponse.Detail = "The request body doesn't respect the valid format: " + strings.Join(reasons, ", ")
+	errResponse.InvalidParams = params
+	return &errResponse
+}
+
+// Decode the JSON body into v. The properties that v doesn't have are rejected rather than ignored
+func decodeStrictJSON(body io.Reader, v interface{}) *common.ErrorResponseError {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		return nil
+	}
+	if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+		if name, unquoteErr := strconv.Unquote(field); unquoteErr == nil {
+			return invalidParamsError([]common.InvalidParam{{Name: name, Reason: "is not a known property"}})
+		}
+	}
+	return &common.InvalidRequestBodyError
+}
@@ -0,0 +1,135 @@
+package image
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+func TestCreateImageRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		request CreateImageRequest
+		want    []common.InvalidParam
+	}{
+		{
+			name:    "valid",
+			request: CreateImageRequest{Name: "cat", Extension: "png", Height: 10, Length: 20},
+		},
+		{
+			name:    "dimensions read from the file",
+			request: CreateImageRequest{Name: "cat", Extension: "JPG"},
+		},
+		{
+			name:    "largest dimensions",
+			request: CreateImageRequest{Name: "cat", Extension: "png", Height: 65535, Length: 65535},
+		},
+		{
+			name:    "name of 64 characters",
+			request: CreateImageRequest{Name: strings.Repeat("é", 64), Extension: "png"},
+		},
+		{
+			name: "missing fields",
+			want: []common.InvalidParam{{Name: "name", Reason: "is required"},
+				{Name: "extension", Reason: "is required"}},
+		},
+		{
+			name:    "name too long",
+			request: CreateImageRequest{Name: strings.Repeat("a", 65), Extension: "png"},
+			want:    []common.InvalidParam{{Name: "name", Reason: "must be at most 64 characters long"}},
+		},
+		{
+			name:    "name with spaces around",
+			request: CreateImageRequest{Name: " cat", Extension: "png"},
+			want:    []common.InvalidParam{{Name: "name", Reason: "must not start or end with a space"}},
+		},
+		{
+			name:    "name with a control character",
+			request: CreateImageRequest{Name: "c\nat", Extension: "png"},
+			want:    []common.InvalidParam{{Name: "name", Reason: "must not contain control characters"}},
+		},
+		{
+			name:    "invalid UTF-8",
+			request: CreateImageRequest{Name: "c\xffat", Extension: "png"},
+			want:    []common.InvalidParam{{Name: "name", Reason: "must be valid UTF-8"}},
+		},
+		{
+			name:    "extension that can't be sniffed",
+			request: CreateImageRequest{Name: "cat", Extension: "tiff"},
+			want: []common.InvalidParam{{Name: "extension",
+				Reason: "must be one of bmp, gif, jpeg, jpg, png, webp in lower or upper case"}},
+		},
+		{
+			name:    "extension in mixed case",
+			request: CreateImageRequest{Name: "cat", Extension: "Png"},
+			want: []common.InvalidParam{{Name: "extension",
+				Reason: "must be one of bmp, gif, jpeg, jpg, png, webp in lower or upper case"}},
+		},
+		{
+			name:    "dimensions out of range",
+			request: CreateImageRequest{Name: "cat", Extension: "png", Height: -1, Length: 65536},
+			want: []common.InvalidParam{{Name: "height", Reason: "must be between 0 and 65535"},
+				{Name: "length", Reason: "must be between 0 and 65535"}},
+		},
+		{
+			name:    "invalid organisation",
+			request: CreateImageRequest{Name: "cat", Extension: "png", Organisation: "team"},
+			want:    []common.InvalidParam{{Name: "organisation", Reason: "must be a uuid"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.request.validate(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeStrictJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		want       CreateImageRequest
+		wantDetail string
+	}{
+		{
+			name: "known properties",
+			body: `{"name": "cat", "extension": "png"}`,
+			want: CreateImageRequest{Name: "cat", Extension: "png"},
+		},
+		{
+			name:       "unknown property",
+			body:       `{"name": "cat", "colour": "red"}`,
+			wantDetail: "The request body doesn't respect the valid format: colour is not a known property",
+		},
+		{
+			name:       "invalid JSON",
+			body:       `{"name": `,
+			wantDetail: common.InvalidRequestBodyError.Detail,
+		},
+		{
+			name:       "wrong type",
+			body:       `{"height": "tall"}`,
+			wantDetail: common.InvalidRequestBodyError.Detail,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got CreateImageRequest
+			errResponse := decodeStrictJSON(strings.NewReader(tt.body), &got)
+			if tt.wantDetail == "" {
+				if errResponse != nil || got != tt.want {
+					t.Errorf("got %+v and %v, want %+v", got, errResponse, tt.want)
+				}
+				return
+			}
+			if errResponse == nil || errResponse.Id != common.InvalidRequestBodyError.Id ||
+				errResponse.Detail != tt.wantDetail {
+				t.Errorf("got %v, want an InvalidRequestBodyError with the detail %q", errResponse, tt.wantDetail)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package image
+
+import "strings"
+
+// Prefix of the paths of the v2 API
+const v2Prefix = "/v2"
+
+// Return the prefix of the version of the API targeted by the path template, or an empty string for v1 and the
+// unprefixed paths
+func versionPrefixOf(template string) string {
+	for _, prefix := range []string{"/v1", v2Prefix} {
+		if strings.HasPrefix(template, prefix+"/") {
+			return prefix
+		}
+	}
+	return ""
+}
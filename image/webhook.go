@@ -0,0 +1,454 @@
+package image
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventImageCreated  = "image.created"
+	EventImageUploaded = "image.uploaded"
+	EventImageUpdated  = "image.updated"
+	EventImageDeleted  = "image.deleted"
+)
+
+var validEvents = map[string]bool{
+	EventImageCreated:  true,
+	EventImageUploaded: true,
+	EventImageUpdated:  true,
+	EventImageDeleted:  true,
+}
+
+type Webhook struct {
+	UUID   uuid.UUID
+	Owner  string
+	URL    string
+	Secret string
+	// Events delivered to the webhook. A webhook without any event receives every event
+	Events    []string
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
+	ID      int64
+	Webhook uuid.UUID
+	Event   string
+	Payload string
+	// Number of attempts made so far and HTTP status of the last one. The status is 0 if no response was received
+	Attempts      int
+	LastStatus    int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	DeliveredAt   sql.NullTime
+	// Time at which the delivery was abandoned after too many failed attempts
+	FailedAt sql.NullTime
+}
+
+// Return true if the webhook subscribed to the event
+func (w Webhook) receives(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Return false if one of the requested events isn't supported
+func validateEvents(events []string) bool {
+	for _, e := range events {
+		if !validEvents[e] {
+			return false
+		}
+	}
+	return true
+}
+
+// Networks the webhooks can't be delivered to since they are internal to the cluster or to the cloud provider:
+// private, shared, loopback, link-local (including the metadata server), multicast and unspecified addresses
+var blockedWebhookNetworks = mustParseCIDRs("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+	"169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4",
+	"240.0.0.0/4", "::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8")
+
+var errBlockedWebhookAddress = errors.New("error the webhook resolves to an internal address")
+
+// Return true if the url is an absolute http or https url that fits in the database and whose host only resolves to
+// public addresses
+func validateWebhookURL(webhookURL string) bool {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || len(webhookURL) > 255 {
+		return false
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return false
+	}
+
+	addresses, err := net.LookupIP(parsed.Hostname())
+	if err != nil || len(addresses) == 0 {
+		return false
+	}
+	for _, address := range addresses {
+		if !isPublicAddress(address) {
+			return false
+		}
+	}
+	return true
+}
+
+// Return true if the address isn't part of a blocked network
+func isPublicAddress(address net.IP) bool {
+	if ipv4 := address.To4(); ipv4 != nil {
+		address = ipv4
+	}
+	for _, network := range blockedWebhookNetworks {
+		if network.Contains(address) {
+			return false
+		}
+	}
+	return true
+}
+
+// Refuse the connections to an address that isn't public. The check is made on the address that is dialed, after
+// the name resolution, so that a host resolving to another address after being validated is still blocked
+func dialPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicAddress(ip) {
+		return errBlockedWebhookAddress
+	}
+	return nil
+}
+
+// Parse the CIDR notations, panicking on an invalid one
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Generate a new webhook for the user along with the secret used to sign its payloads
+func newWebhook(owner string, request CreateWebhookRequest) (Webhook, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return Webhook{}, err
+	}
+
+	return Webhook{
+		UUID:      uuid.New(),
+		Owner:     owner,
+		URL:       request.Url,
+		Secret:    "whsec_" + hex.EncodeToString(secret),
+		Events:    request.Events,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Return the signature of the payload sent at the unix timestamp. The timestamp is signed along with the payload so
+// that receivers can reject replayed deliveries
+func signPayload(secret string, timestamp int64, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Convert a Webhook into a WebhookResponse object. The secret is only included when the webhook is created
+func (w Webhook) toWebhookResponse() WebhookResponse {
+	return WebhookResponse{
+		Uuid:      w.UUID.String(),
+		Url:       w.URL,
+		Events:    w.Events,
+		CreatedAt: w.CreatedAt,
+	}
+}
+
+// Convert an array of Webhook into an array of WebhookResponse object
+func webhooksToWebhooksResponse(webhooks []Webhook) []WebhookResponse {
+	response := make([]WebhookResponse, 0)
+	for _, w := range webhooks {
+		response = append(response, w.toWebhookResponse())
+	}
+	return response
+}
+
+// Convert a WebhookDelivery into a WebhookDeliveryResponse object
+func (d WebhookDelivery) toWebhookDeliveryResponse() WebhookDeliveryResponse {
+	response := WebhookDeliveryResponse{
+		Id:         d.ID,
+		Event:      d.Event,
+		Payload:    json.RawMessage(d.Payload),
+		Attempts:   d.Attempts,
+		LastStatus: d.LastStatus,
+		LastError:  d.LastError,
+		CreatedAt:  d.CreatedAt,
+	}
+	if d.DeliveredAt.Valid {
+		response.DeliveredAt = &d.DeliveredAt.Time
+	} else if d.FailedAt.Valid {
+		response.FailedAt = &d.FailedAt.Time
+	} else {
+		response.NextAttemptAt = &d.NextAttemptAt
+	}
+	return response
+}
+
+// Create a DB record for the webhook
+func CreateWebhook(db *sql.DB, webhook Webhook) error {
+	uuidToCreate, err := webhook.UUID.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO webhooks (UUID, owner, url, secret, events, createdAt) VALUES (?, ?, ?, ?, ?, ?)",
+		uuidToCreate, webhook.Owner, webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","), webhook.CreatedAt)
+	return err
+}
+
+const webhookColumns = "UUID, owner, url, secret, events, createdAt"
+
+// Return the webhook record associated to the uuid
+func GetWebhook(db *sql.DB, id uuid.UUID) (*Webhook, error) {
+	uuidToGet, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	row := db.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE UUID = ?", uuidToGet)
+	return scanWebhook(row)
+}
+
+// Return the webhooks of the user
+func GetWebhooks(db *sql.DB, username string) ([]Webhook, error) {
+	rows, err := db.Query("SELECT "+webhookColumns+" FROM webhooks WHERE owner = ? ORDER BY createdAt", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// Delete the webhook along with its pending deliveries. The delivered ones are kept in the delivery log
+func DeleteWebhook(db *sql.DB, id uuid.UUID) error {
+	uuidToDelete, err := id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM webhooks WHERE UUID = ?", uuidToDelete)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM webhook_deliveries WHERE webhook = ? AND deliveredAt IS NULL AND failedAt IS NULL",
+		uuidToDelete)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Enqueue a delivery of the event for every webhook of its owner subscribed to it. Return the number of deliveries
+func EnqueueWebhookDeliveries(db *sql.DB, event Event) (int, error) {
+	webhooks, err := GetWebhooks(db, event.Owner)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := json.Marshal(event.toEventPayload())
+	if err != nil {
+		return 0, err
+	}
+
+	enqueued := 0
+	now := time.Now().UTC()
+	for _, webhook := range webhooks {
+		if !webhook.receives(event.Type) {
+			continue
+		}
+		err = CreateWebhookDelivery(db, WebhookDelivery{Webhook: webhook.UUID, Event: event.Type,
+			Payload: string(payload), NextAttemptAt: now, CreatedAt: now})
+		if err != nil {
+			return enqueued, err
+		}
+		enqueued++
+	}
+	return enqueued, nil
+}
+
+// Create a DB record for the delivery
+func CreateWebhookDelivery(db *sql.DB, delivery WebhookDelivery) error {
+	webhookUUID, err := delivery.Webhook.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO webhook_deliveries (webhook, event, payload, nextAttemptAt, createdAt) "+
+		"VALUES (?, ?, ?, ?, ?)", webhookUUID, delivery.Event, delivery.Payload, delivery.NextAttemptAt,
+		delivery.CreatedAt)
+	return err
+}
+
+const webhookDeliveryColumns = "id, webhook, event, payload, attempts, lastStatus, lastError, nextAttemptAt, " +
+	"createdAt, deliveredAt, failedAt"
+
+// Return the delivery record associated to the id
+func GetWebhookDelivery(db *sql.DB, id int64) (*WebhookDelivery, error) {
+	row := db.QueryRow("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE id = ?", id)
+	return scanWebhookDelivery(row)
+}
+
+// Return the most recent deliveries of the webhook, newest first
+func GetWebhookDeliveries(db *sql.DB, webhook uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	uuidToGet, err := webhook.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE webhook = ? "+
+		"ORDER BY id DESC LIMIT ?", uuidToGet, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+// Return the deliveries that are neither delivered nor abandoned and whose next attempt is due
+func GetDueWebhookDeliveries(db *sql.DB, limit int) ([]WebhookDelivery, error) {
+	rows, err := db.Query("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE deliveredAt IS NULL "+
+		"AND failedAt IS NULL AND nextAttemptAt <= ? ORDER BY nextAttemptAt LIMIT ?", time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+// Claim the delivery until leaseUntil. Return false if another worker claimed it first
+func ClaimWebhookDelivery(db *sql.DB, delivery WebhookDelivery, leaseUntil time.Time) (bool, error) {
+	result, err := db.Exec("UPDATE webhook_deliveries SET nextAttemptAt = ?, attempts = attempts + 1 "+
+		"WHERE id = ? AND attempts = ? AND deliveredAt IS NULL AND failedAt IS NULL", leaseUntil, delivery.ID,
+		delivery.Attempts)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Record the outcome of an attempt. A successful attempt marks the delivery as delivered. Otherwise the next attempt
+// is scheduled at nextAttemptAt, or the delivery is abandoned if nextAttemptAt is nil
+func RecordWebhookAttempt(db *sql.DB, id int64, status int, lastError string, nextAttemptAt *time.Time) error {
+	if len(lastError) > 255 {
+		lastError = lastError[:255]
+	}
+
+	now := time.Now().UTC()
+	var err error
+	switch {
+	case lastError == "":
+		_, err = db.Exec("UPDATE webhook_deliveries SET lastStatus = ?, lastError = '', deliveredAt = ? WHERE id = ?",
+			status, now, id)
+	case nextAttemptAt == nil:
+		_, err = db.Exec("UPDATE webhook_deliveries SET lastStatus = ?, lastError = ?, failedAt = ? WHERE id = ?",
+			status, lastError, now, id)
+	default:
+		_, err = db.Exec("UPDATE webhook_deliveries SET lastStatus = ?, lastError = ?, nextAttemptAt = ? WHERE id = ?",
+			status, lastError, *nextAttemptAt, id)
+	}
+	return err
+}
+
+// Scan every row of the webhook_deliveries table into an array of WebhookDelivery and close the rows
+func scanWebhookDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	defer rows.Close()
+	deliveries := make([]WebhookDelivery, 0)
+
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// Scan a row of the webhook_deliveries table selected with webhookDeliveryColumns into a WebhookDelivery object
+func scanWebhookDelivery(row rowScanner) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var uuidToParse []byte
+
+	err := row.Scan(&delivery.ID, &uuidToParse, &delivery.Event, &delivery.Payload, &delivery.Attempts,
+		&delivery.LastStatus, &delivery.LastError, &delivery.NextAttemptAt, &delivery.CreatedAt,
+		&delivery.DeliveredAt, &delivery.FailedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = delivery.Webhook.UnmarshalBinary(uuidToParse)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Scan a row of the webhooks table selected with webhookColumns into a Webhook object
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	webhook := &Webhook{}
+	var uuidToParse []byte
+	var events string
+
+	err := row.Scan(&uuidToParse, &webhook.Owner, &webhook.URL, &webhook.Secret, &events, &webhook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = webhook.UUID.UnmarshalBinary(uuidToParse)
+	if err != nil {
+		return nil, err
+	}
+	if events != "" {
+		webhook.Events = strings.Split(events, ",")
+	}
+	return webhook, nil
+}
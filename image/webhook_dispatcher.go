@@ -0,0 +1,190 @@
+package image
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dispatcherInterval  = 15 * time.Second
+	dispatcherBatchSize = 50
+	dispatcherLease     = time.Minute
+	webhookTimeout      = 10 * time.Second
+	webhookMaxAttempts  = 10
+	webhookMaxBackoff   = 6 * time.Hour
+	// Number of deliveries sent concurrently so that a slow endpoint doesn't hold back the others
+	dispatcherWorkers = 8
+)
+
+// Background worker sending the enqueued webhook deliveries. Failed deliveries are retried with an exponential
+// backoff until they succeed or webhookMaxAttempts is reached
+type WebhookDispatcher struct {
+	db      *sql.DB
+	client  *http.Client
+	wakeups chan struct{}
+}
+
+// Return a WebhookDispatcher sending the deliveries of the database
+func NewWebhookDispatcher(db *sql.DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:      db,
+		client:  newWebhookClient(),
+		wakeups: make(chan struct{}, 1),
+	}
+}
+
+// Return the client sending the deliveries. It only connects to public addresses, without going through a proxy,
+// and doesn't follow the redirects so that a webhook can't be used to reach the internal services
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: dialPublicAddress}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		// The redirect is returned as is and fails the delivery since it is outside of the 2xx range
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Send the due deliveries periodically or as soon as the dispatcher is woken up. This function never returns
+func (d *WebhookDispatcher) Run() {
+	ticker := time.NewTicker(dispatcherInterval)
+	defer ticker.Stop()
+	for {
+		d.dispatchDueDeliveries()
+		select {
+		case <-ticker.C:
+		case <-d.wakeups:
+		}
+	}
+}
+
+// Ask the dispatcher to send the deliveries without waiting for the next tick
+func (d *WebhookDispatcher) wake() {
+	select {
+	case d.wakeups <- struct{}{}:
+	default:
+	}
+}
+
+// Send the due deliveries until none is left. The deliveries of a batch are shared by dispatcherWorkers workers
+func (d *WebhookDispatcher) dispatchDueDeliveries() {
+	for {
+		deliveries, err := GetDueWebhookDeliveries(d.db, dispatcherBatchSize)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+
+		queue := make(chan WebhookDelivery)
+		var workers sync.WaitGroup
+		for i := 0; i < dispatcherWorkers; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for delivery := range queue {
+					d.dispatch(delivery)
+				}
+			}()
+		}
+		for _, delivery := range deliveries {
+			queue <- delivery
+		}
+		close(queue)
+		workers.Wait()
+		if len(deliveries) < dispatcherBatchSize {
+			return
+		}
+	}
+}
+
+// Send a single delivery. Another replica may have claimed the delivery, in which case it is skipped
+func (d *WebhookDispatcher) dispatch(delivery WebhookDelivery) {
+	claimed, err := ClaimWebhookDelivery(d.db, delivery, time.Now().UTC().Add(dispatcherLease))
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	// The delivery fails for good if the webhook was deleted since it was enqueued. Otherwise the lease expires if
+	// the webhook can't be read so that the delivery is retried later
+	webhook, err := GetWebhook(d.db, delivery.Webhook)
+	if err == sql.ErrNoRows {
+		err = RecordWebhookAttempt(d.db, delivery.ID, 0, "the webhook was deleted", nil)
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	status, err := d.send(*webhook, delivery)
+	if err == nil {
+		err = RecordWebhookAttempt(d.db, delivery.ID, status, "", nil)
+		if err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+
+	var nextAttemptAt *time.Time
+	if delivery.Attempts+1 < webhookMaxAttempts {
+		next := time.Now().UTC().Add(webhookBackoff(delivery.Attempts + 1))
+		nextAttemptAt = &next
+	}
+	err = RecordWebhookAttempt(d.db, delivery.ID, status, err.Error(), nextAttemptAt)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// POST the signed payload to the url of the webhook. Any response outside of the 2xx range is an error
+func (d *WebhookDispatcher) send(webhook Webhook, delivery WebhookDelivery) (int, error) {
+	request, err := http.NewRequest("POST", webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Event", delivery.Event)
+	request.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	request.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set("X-Webhook-Signature", signPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	// The body is drained so that the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64<<10))
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected response status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// Return the delay before retrying a delivery after the number of failed attempts
+func webhookBackoff(attempts int) time.Duration {
+	delay := time.Duration(1<<uint(attempts)) * 15 * time.Second
+	if delay <= 0 || delay > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return delay
+}
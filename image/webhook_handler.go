@@ -0,0 +1,185 @@
+package image
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/wtrep/shopify-backend-challenge-image/common"
+)
+
+const webhookDeliveryLogSize = 100
+
+// Handle the API request to subscribe a webhook of the authenticated user to image events
+func (h *Handler) HandlePostWebhook(w http.ResponseWriter, r *http.Request) {
+	var request CreateWebhookRequest
+	w.Header().Set("Content-Type", "application/json")
+	// The user is authenticated first since validating the url resolves its host
+	username, errResponse := h.authenticateUser(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	errResponse = decodeStrictJSON(r.Body, &request)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+	if !validateWebhookURL(request.Url) || !validateEvents(request.Events) {
+		common.RespondWithError(w, &common.InvalidWebhookError)
+		return
+	}
+
+	webhook, err := newWebhook(username, request)
+	if err != nil {
+		common.RespondWithError(w, &common.WebhookDBError)
+		return
+	}
+	err = CreateWebhook(h.db, webhook)
+	if err != nil {
+		common.RespondWithError(w, &common.DatabaseInsertionError)
+		return
+	}
+
+	response := CreateWebhookResponse{
+		WebhookResponse: webhook.toWebhookResponse(),
+		Secret:          webhook.Secret,
+	}
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to list the webhooks of the authenticated user
+func (h *Handler) HandleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, errResponse := h.authenticateUser(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	webhooks, err := GetWebhooks(h.db, username)
+	if err != nil {
+		common.RespondWithError(w, &common.WebhookDBError)
+		return
+	}
+
+	response := webhooksToWebhooksResponse(webhooks)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to delete a webhook
+func (h *Handler) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	webhook, errResponse := h.getOwnedWebhook(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	err := DeleteWebhook(h.db, webhook.UUID)
+	if err != nil {
+		common.RespondWithError(w, &common.WebhookDBError)
+		return
+	}
+
+	response := webhook.toWebhookResponse()
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to get the most recent deliveries of a webhook
+func (h *Handler) HandleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	webhook, errResponse := h.getOwnedWebhook(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	deliveries, err := GetWebhookDeliveries(h.db, webhook.UUID, webhookDeliveryLogSize)
+	if err != nil {
+		common.RespondWithError(w, &common.WebhookDBError)
+		return
+	}
+
+	response := make(WebhookDeliveriesResponse, 0)
+	for _, delivery := range deliveries {
+		response = append(response, delivery.toWebhookDeliveryResponse())
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Handle the API request to send a delivery of a webhook again. A new delivery of the same payload is enqueued so that
+// the log of the original one is kept
+func (h *Handler) HandlePostRedeliver(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	webhook, errResponse := h.getOwnedWebhook(r)
+	if errResponse != nil {
+		common.RespondWithError(w, errResponse)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["delivery"], 10, 64)
+	if err != nil {
+		common.RespondWithError(w, &common.WebhookDeliveryNotFoundError)
+		return
+	}
+	original, err := GetWebhookDelivery(h.db, id)
+	if err != nil || original.Webhook != webhook.UUID {
+		common.RespondWithError(w, &common.WebhookDeliveryNotFoundError)
+		return
+	}
+
+	now := time.Now().UTC()
+	delivery := WebhookDelivery{Webhook: webhook.UUID, Event: original.Event, Payload: original.Payload,
+		NextAttemptAt: now, CreatedAt: now}
+	err = CreateWebhookDelivery(h.db, delivery)
+	if err != nil {
+		common.RespondWithError(w, &common.DatabaseInsertionError)
+		return
+	}
+	h.webhooks.wake()
+
+	w.WriteHeader(http.StatusAccepted)
+	response := delivery.toWebhookDeliveryResponse()
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		common.RespondWithError(w, &common.JSONEncoderError)
+	}
+}
+
+// Return the webhook identified by the uuid route variable if it belongs to the authenticated user
+func (h *Handler) getOwnedWebhook(r *http.Request) (*Webhook, *common.ErrorResponseError) {
+	uuidToGet, err := uuid.Parse(mux.Vars(r)["uuid"])
+	if err != nil {
+		return nil, &common.InvalidUUIDError
+	}
+
+	username, errResponse := h.authenticateUser(r)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+
+	webhook, err := GetWebhook(h.db, uuidToGet)
+	if err != nil || webhook.Owner != username {
+		return nil, &common.WebhookNotFoundError
+	}
+	return webhook, nil
+}
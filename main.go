@@ -1,9 +1,23 @@
 package main
 
 import (
+	"os"
+
 	"github.com/wtrep/shopify-backend-challenge-image/image"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		image.RunReconcileCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		image.RunAccountJobCommand(image.AccountJobExport, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "erase" {
+		image.RunAccountJobCommand(image.AccountJobErasure, os.Args[2:])
+		return
+	}
 	image.SetupAndServeRoutes()
 }